@@ -0,0 +1,241 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+)
+
+// Global asset IDs on the NEO chain
+const (
+	NEOAssetID = "c56f33fc6ecfcd0c225c4ab356fee59390af8560be0e930faebe74a6daff7c9b"
+	GASAssetID = "602c79718b16e442de58778e148d0b1084e3b2dffd5de6b7b16cee7969282de7"
+)
+
+// Script hash and decimals of the NEP-5 token reported alongside NEO and GAS
+var (
+	NEP5TokenHash     = os.Getenv("NEP5_TOKEN_HASH")
+	NEP5TokenDecimals = 8
+)
+
+// How long an address lookup is cached, lookups are also refreshed once a new block arrives
+var AddressCacheTTL = 30 * time.Second
+
+// Number of token transfers returned for an address
+var AddressTransferLimit = 20
+
+// AddressDetails holds an address's NEO, GAS and token balances with its recent NEP-5 token transfers,
+// NEO and GAS are UTXO assets whose transfers aren't indexed by the nodes and so aren't listed
+type AddressDetails struct {
+	Address        string          `json:"address"`
+	NEO            float64         `json:"neo"`
+	GAS            float64         `json:"gas"`
+	Token          float64         `json:"token"`
+	TokenHash      string          `json:"token_hash,omitempty"`
+	TokenTransfers []TokenTransfer `json:"token_transfers"`
+	BlockHeight    int64           `json:"block_height"`
+	UpdatedAt      int64           `json:"updated_at"`
+}
+
+// TokenTransfer is a transfer of the NEP-5 token to or from an address
+type TokenTransfer struct {
+	TxID        string  `json:"txid"`
+	Direction   string  `json:"direction"`
+	Counterpart string  `json:"counterpart"`
+	Amount      float64 `json:"amount"`
+	BlockIndex  int64   `json:"block_index"`
+	Timestamp   int64   `json:"timestamp"`
+}
+
+type accountState struct {
+	Balances []struct {
+		Asset string `json:"asset"`
+		Value string `json:"value"`
+	} `json:"balances"`
+}
+
+type nep5Balances struct {
+	Balance []struct {
+		AssetHash string `json:"asset_hash"`
+		Amount    string `json:"amount"`
+	} `json:"balance"`
+}
+
+type nep5Transfer struct {
+	Timestamp       int64  `json:"timestamp"`
+	AssetHash       string `json:"asset_hash"`
+	TransferAddress string `json:"transfer_address"`
+	Amount          string `json:"amount"`
+	BlockIndex      int64  `json:"block_index"`
+	TxHash          string `json:"tx_hash"`
+}
+
+type nep5Transfers struct {
+	Sent     []nep5Transfer `json:"sent"`
+	Received []nep5Transfer `json:"received"`
+}
+
+func AddressKey(address string) string {
+	return "address-" + address
+}
+
+// IsValidAddress does a quick shape check on a NEO address before it's sent to a node
+func IsValidAddress(address string) bool {
+	return len(address) == 34 && strings.HasPrefix(address, "A")
+}
+
+// GetAddressDetails returns the cached lookup for an address, fetching it again once it's expired or behind the chain
+func (svc *Service) GetAddressDetails(address string) (details AddressDetails, err error) {
+	err = svc.GetRedis(AddressKey(address), &details)
+	if err == nil && details.BlockHeight >= svc.CurrentBlockHeight() {
+		return
+	}
+	if err != nil && err != redis.Nil {
+		return
+	}
+
+	details, err = svc.FetchAddressDetails(address)
+	if err != nil {
+		return
+	}
+
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return
+	}
+
+	err = svc.RedisClient.Set(AddressKey(address), detailsJSON, AddressCacheTTL).Err()
+	return
+}
+
+// FetchAddressDetails reads an address's balances and recent NEP-5 token transfers from the node pool
+func (svc *Service) FetchAddressDetails(address string) (details AddressDetails, err error) {
+	details = AddressDetails{
+		Address:        address,
+		TokenHash:      normaliseScriptHash(NEP5TokenHash),
+		TokenTransfers: []TokenTransfer{},
+		BlockHeight:    svc.CurrentBlockHeight(),
+		UpdatedAt:      time.Now().Unix(),
+	}
+
+	rpc, err := svc.RPC()
+	if err != nil {
+		return
+	}
+
+	var state accountState
+	err = rpc.Call("getaccountstate", []interface{}{address}, &state)
+	if err != nil {
+		return
+	}
+
+	for _, balance := range state.Balances {
+		value, ok := new(big.Float).SetString(balance.Value)
+		if !ok {
+			continue
+		}
+		amount, _ := value.Float64()
+
+		switch normaliseScriptHash(balance.Asset) {
+		case NEOAssetID:
+			details.NEO = amount
+		case GASAssetID:
+			details.GAS = amount
+		}
+	}
+
+	if details.TokenHash == "" {
+		return
+	}
+
+	var balances nep5Balances
+	err = rpc.Call("getnep5balances", []interface{}{address}, &balances)
+	if err != nil {
+		return
+	}
+
+	for _, balance := range balances.Balance {
+		if normaliseScriptHash(balance.AssetHash) == details.TokenHash {
+			details.Token = GetTokenAmount(balance.Amount)
+		}
+	}
+
+	var transfers nep5Transfers
+	err = rpc.Call("getnep5transfers", []interface{}{address}, &transfers)
+	if err != nil {
+		return
+	}
+
+	details.TokenTransfers = append(
+		makeTransfers(transfers.Sent, "sent", details.TokenHash),
+		makeTransfers(transfers.Received, "received", details.TokenHash)...,
+	)
+
+	sort.SliceStable(details.TokenTransfers, func(i, j int) bool {
+		return details.TokenTransfers[i].Timestamp > details.TokenTransfers[j].Timestamp
+	})
+
+	if len(details.TokenTransfers) > AddressTransferLimit {
+		details.TokenTransfers = details.TokenTransfers[:AddressTransferLimit]
+	}
+
+	return
+}
+
+func makeTransfers(transfers []nep5Transfer, direction, tokenHash string) []TokenTransfer {
+	var result []TokenTransfer
+	for _, transfer := range transfers {
+		if normaliseScriptHash(transfer.AssetHash) != tokenHash {
+			continue
+		}
+
+		result = append(result, TokenTransfer{
+			TxID:        transfer.TxHash,
+			Direction:   direction,
+			Counterpart: transfer.TransferAddress,
+			Amount:      GetTokenAmount(transfer.Amount),
+			BlockIndex:  transfer.BlockIndex,
+			Timestamp:   transfer.Timestamp,
+		})
+	}
+
+	return result
+}
+
+// GetTokenAmount converts a raw NEP-5 integer amount using the token's decimals
+func GetTokenAmount(raw string) float64 {
+	value, ok := new(big.Float).SetString(raw)
+	if !ok {
+		return 0
+	}
+
+	divisor := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(NEP5TokenDecimals)), nil))
+	amount, _ := value.Quo(value, divisor).Float64()
+
+	return amount
+}
+
+func (svc *Service) AddressHandler(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	if !IsValidAddress(address) {
+		http.Error(w, "Invalid address", http.StatusBadRequest)
+		return
+	}
+
+	details, err := svc.GetAddressDetails(address)
+	if err != nil {
+		svc.Logger.Log("error", fmt.Sprintf("Unable to look up %s: %v", address, err))
+		http.Error(w, "Unable to look up address", http.StatusBadGateway)
+		return
+	}
+
+	json.NewEncoder(w).Encode(details)
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/parnurzeal/gorequest"
+)
+
+const (
+	ChainStalledAlert   = "chain-stalled"
+	ChainRecoveredAlert = "chain-recovered"
+)
+
+// Time without a new block across all nodes before the chain is considered stalled
+var ChainStallTime = envDuration("CHAIN_STALL_TIME", 2*time.Minute)
+
+// Number of alerts kept in redis
+var ChainAlertHistory = envInt64("CHAIN_ALERT_HISTORY", 100)
+
+// URL that alerts are posted to, alerts are only logged and stored when empty
+var ChainAlertWebhook = os.Getenv("CHAIN_ALERT_WEBHOOK")
+
+type ChainAlert struct {
+	Type        string  `json:"type"`
+	BlockHeight int64   `json:"block_height"`
+	LastBlockAt int64   `json:"last_block_at"`
+	StalledFor  float64 `json:"stalled_for"`
+	Timestamp   int64   `json:"timestamp"`
+}
+
+// CheckChainStall raises an alert once no node has produced a new block within ChainStallTime
+func (svc *Service) CheckChainStall() {
+	if svc.Internals.ChainStalled || time.Since(svc.Internals.UpdatedAt) < ChainStallTime {
+		return
+	}
+
+	// Make sure every node is stuck before alerting, a higher node will be picked up on the next poll
+	svc.ProbeNodes()
+	if svc.Chain.BestHeight() > svc.Internals.BlockHeight {
+		return
+	}
+
+	svc.Internals.ChainStalled = true
+	svc.RaiseChainAlert(ChainStalledAlert)
+
+	err := svc.SetBlockchainData()
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+	}
+
+	svc.PushAppUpdates()
+}
+
+// RaiseChainAlert logs and stores an alert and fires the webhook if one is configured
+func (svc *Service) RaiseChainAlert(alertType string) {
+	alert := ChainAlert{
+		Type:        alertType,
+		BlockHeight: svc.Internals.BlockHeight,
+		LastBlockAt: svc.Internals.UpdatedAt.Unix(),
+		StalledFor:  time.Since(svc.Internals.UpdatedAt).Seconds(),
+		Timestamp:   time.Now().Unix(),
+	}
+
+	svc.Logger.Log("alert", alert.Type, "block_height", alert.BlockHeight, "stalled_for", alert.StalledFor)
+
+	alertJSON, err := json.Marshal(alert)
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		return
+	}
+
+	err = svc.RedisClient.LPush("chain-alerts", alertJSON).Err()
+	if err == nil {
+		err = svc.RedisClient.LTrim("chain-alerts", 0, ChainAlertHistory-1).Err()
+	}
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+	}
+
+	if ChainAlertWebhook == "" {
+		return
+	}
+
+	go func() {
+		_, _, errs := gorequest.New().
+			Timeout(NodeTimeout).
+			Post(ChainAlertWebhook).
+			Send(alert).
+			End()
+		if errs != nil {
+			err := aggregateErrors(fmt.Sprintf("Unable to send %s alert", alert.Type), errs)
+			svc.Logger.Log("error", err.Error())
+		}
+	}()
+}
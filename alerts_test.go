@@ -0,0 +1,75 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckChainStallWaitsForEveryNode(t *testing.T) {
+	defer func(webhook string) { ChainAlertWebhook = webhook }(ChainAlertWebhook)
+	ChainAlertWebhook = ""
+
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	chain := NewMockChain(12, 15*time.Second)
+	svc.Chain = chain
+
+	tests := []struct {
+		name    string
+		height  int64
+		since   time.Duration
+		stalled bool
+		alerts  int64
+	}{
+		{"recent block", 12, time.Second, false, 0},
+		// Another node has moved on, it's picked up on the next poll
+		{"behind the best node", 10, ChainStallTime + time.Second, false, 0},
+		{"every node stuck", 12, ChainStallTime + time.Second, true, 1},
+		// Only the first check raises an alert
+		{"still stuck", 12, 2 * ChainStallTime, true, 1},
+	}
+
+	for _, test := range tests {
+		svc.Internals.BlockHeight = test.height
+		svc.Internals.UpdatedAt = time.Now().Add(-test.since)
+
+		svc.CheckChainStall()
+
+		if svc.Internals.ChainStalled != test.stalled {
+			t.Errorf("%s: stalled = %t, expected %t", test.name, svc.Internals.ChainStalled, test.stalled)
+		}
+
+		alerts, err := svc.RedisClient.LLen("chain-alerts").Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alerts != test.alerts {
+			t.Errorf("%s: %d alerts, expected %d", test.name, alerts, test.alerts)
+		}
+	}
+}
+
+func TestRaiseChainAlertKeepsHistory(t *testing.T) {
+	defer func(webhook string, history int64) {
+		ChainAlertWebhook = webhook
+		ChainAlertHistory = history
+	}(ChainAlertWebhook, ChainAlertHistory)
+	ChainAlertWebhook = ""
+	ChainAlertHistory = 3
+
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	for i := 0; i < 5; i++ {
+		svc.RaiseChainAlert(ChainStalledAlert)
+	}
+
+	alerts, err := svc.RedisClient.LLen("chain-alerts").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alerts != ChainAlertHistory {
+		t.Errorf("%d alerts kept, expected %d", alerts, ChainAlertHistory)
+	}
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/a-h/round"
+)
+
+// Providers at least this sharp are used to judge whether our prices are out of line
+var SharpProviderThreshold = 0.85
+
+// Relative difference from the sharp fair odds before one of our prices is flagged
+var ValueThreshold = 0.05
+
+// Bankroll used to size the stakes of each arbitrage
+var ArbitrageBankroll = float64(100)
+
+type ArbitrageReport struct {
+	GeneratedAt int64       `json:"generated_at"`
+	Arbitrages  []Arbitrage `json:"arbitrages"`
+	ValueFlags  []ValueFlag `json:"value_flags"`
+}
+
+type Arbitrage struct {
+	MatchID string         `json:"match_id"`
+	Name    string         `json:"name"`
+	Margin  float64        `json:"margin"`
+	Legs    []ArbitrageLeg `json:"legs"`
+}
+
+type ArbitrageLeg struct {
+	Outcome  int     `json:"outcome"`
+	Provider string  `json:"provider"`
+	Odds     float64 `json:"odds"`
+	Stake    float64 `json:"stake"`
+}
+
+// ValueFlag marks one of our generated prices that is out of line with the sharp providers
+type ValueFlag struct {
+	MatchID  string  `json:"match_id"`
+	Name     string  `json:"name"`
+	Outcome  int     `json:"outcome"`
+	Side     string  `json:"side"`
+	Odds     float64 `json:"odds"`
+	FairOdds float64 `json:"fair_odds"`
+	Edge     float64 `json:"edge"`
+}
+
+// GenerateArbitrageReport scans the latest provider prices for sure bets and checks our ladders against the sharp books
+func (svc *Service) GenerateArbitrageReport() {
+	var allMatches []Match
+	err := svc.GetRedis("all-matches", &allMatches)
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		return
+	}
+
+	var providerOdds map[string][]ProviderOdd
+	err = svc.GetRedis("provider-odds", &providerOdds)
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		return
+	}
+
+	report := ArbitrageReport{
+		GeneratedAt: time.Now().Unix(),
+		Arbitrages:  []Arbitrage{},
+		ValueFlags:  []ValueFlag{},
+	}
+
+	for _, match := range allMatches {
+		matchID := match.ID()
+		odds := providerOdds[matchID]
+		if len(odds) < 1 {
+			continue
+		}
+
+		if arbitrage, ok := FindArbitrage(match, odds); ok {
+			report.Arbitrages = append(report.Arbitrages, arbitrage)
+		}
+
+		report.ValueFlags = append(report.ValueFlags, FindValue(match, odds)...)
+	}
+
+	err = svc.SetRedis("arbitrage-report", &report)
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		return
+	}
+}
+
+// FindArbitrage takes the best price for each outcome across providers and checks whether backing them all guarantees a profit
+func FindArbitrage(match Match, allOdds []ProviderOdd) (Arbitrage, bool) {
+	legs := make([]ArbitrageLeg, match.Outcomes)
+
+	for _, odds := range allOdds {
+		for outcome := 0; outcome < match.Outcomes; outcome++ {
+			price, err := strconv.ParseFloat(odds.Odds.GetOutcome(outcome), 64)
+			if err != nil || price <= 1 {
+				continue
+			}
+
+			if price > legs[outcome].Odds {
+				legs[outcome] = ArbitrageLeg{
+					Outcome:  outcome,
+					Provider: odds.Provider,
+					Odds:     price,
+				}
+			}
+		}
+	}
+
+	bookSum := float64(0)
+	for _, leg := range legs {
+		if leg.Odds <= 0 {
+			return Arbitrage{}, false
+		}
+
+		bookSum += 1 / leg.Odds
+	}
+
+	if bookSum >= 1 {
+		return Arbitrage{}, false
+	}
+
+	// Stake each leg in proportion to its implied probability so every result returns the same amount
+	for i, leg := range legs {
+		legs[i].Stake = round.AwayFromZero(ArbitrageBankroll*(1/leg.Odds)/bookSum, 2)
+	}
+
+	return Arbitrage{
+		MatchID: match.ID(),
+		Name:    match.Name,
+		Margin:  round.AwayFromZero(1-bookSum, 4),
+		Legs:    legs,
+	}, true
+}
+
+// FindValue compares our best back and lay prices with the fair odds of the sharpest providers
+func FindValue(match Match, allOdds []ProviderOdd) []ValueFlag {
+	var flags []ValueFlag
+
+	var sharpBooks []providerBook
+	for _, book := range makeProviderBooks(allOdds, match.Outcomes) {
+		if getSharpness(book.provider) >= SharpProviderThreshold {
+			sharpBooks = append(sharpBooks, book)
+		}
+	}
+
+	if len(sharpBooks) < 1 {
+		return flags
+	}
+
+	fair := normaliseProbabilities(combineProbabilities(sharpBooks, sharpnessWeights(sharpBooks, match.Outcomes)))
+	bestOdds := FindBestOdds(match)
+
+	for outcome, probability := range fair {
+		if probability <= 0 {
+			continue
+		}
+
+		fairOdds := 1 / probability
+
+		// Backing above fair odds or laying below them gives bettors an edge over us
+		back := getOutcomeOdds(bestOdds.Back, outcome)
+		if back > 0 && back > fairOdds*(1+ValueThreshold) {
+			flags = append(flags, makeValueFlag(match, outcome, SideBack, back, fairOdds))
+		}
+
+		lay := getOutcomeOdds(bestOdds.Lay, outcome)
+		if lay > 0 && lay < fairOdds*(1-ValueThreshold) {
+			flags = append(flags, makeValueFlag(match, outcome, SideLay, lay, fairOdds))
+		}
+	}
+
+	return flags
+}
+
+func makeValueFlag(match Match, outcome int, side string, odds, fairOdds float64) ValueFlag {
+	return ValueFlag{
+		MatchID:  match.ID(),
+		Name:     match.Name,
+		Outcome:  outcome,
+		Side:     side,
+		Odds:     odds,
+		FairOdds: round.AwayFromZero(fairOdds, 2),
+		Edge:     round.AwayFromZero(odds/fairOdds-1, 4),
+	}
+}
+
+func (svc *Service) ArbitrageReportHandler(w http.ResponseWriter, r *http.Request) {
+	var report ArbitrageReport
+	err := svc.GetRedis("arbitrage-report", &report)
+	if err != nil {
+		http.Error(w, "Report not available", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
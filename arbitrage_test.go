@@ -0,0 +1,171 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestFindArbitrage(t *testing.T) {
+	tests := []struct {
+		name     string
+		outcomes int
+		allOdds  []ProviderOdd
+		found    bool
+		margin   float64
+		legs     []ArbitrageLeg
+	}{
+		{
+			name:     "sure bet across providers",
+			outcomes: 2,
+			allOdds: []ProviderOdd{
+				{Provider: "betfair", Odds: twoWayOdd("3", "1.4")},
+				{Provider: "bet365", Odds: twoWayOdd("2.5", "2")},
+			},
+			found:  true,
+			margin: 0.1667,
+			legs: []ArbitrageLeg{
+				{Outcome: 0, Provider: "betfair", Odds: 3, Stake: 40},
+				{Outcome: 1, Provider: "bet365", Odds: 2, Stake: 60},
+			},
+		},
+		{
+			name:     "every provider has a margin",
+			outcomes: 2,
+			allOdds: []ProviderOdd{
+				{Provider: "betfair", Odds: twoWayOdd("1.9", "1.9")},
+				{Provider: "bet365", Odds: twoWayOdd("1.8", "2")},
+			},
+		},
+		{
+			name:     "no price for the draw",
+			outcomes: 3,
+			allOdds: []ProviderOdd{
+				{Provider: "betfair", Odds: twoWayOdd("5", "5")},
+				{Provider: "bet365", Odds: ThreeWayOdd{HomeOdds: "5", AwayOdds: "5", DrawOdds: "1"}},
+			},
+		},
+		{
+			name:     "unparseable prices are skipped",
+			outcomes: 2,
+			allOdds: []ProviderOdd{
+				{Provider: "betfair", Odds: twoWayOdd("-", "2.5")},
+				{Provider: "bet365", Odds: twoWayOdd("2.5", "")},
+			},
+			found:  true,
+			margin: 0.2,
+			legs: []ArbitrageLeg{
+				{Outcome: 0, Provider: "bet365", Odds: 2.5, Stake: 50},
+				{Outcome: 1, Provider: "betfair", Odds: 2.5, Stake: 50},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		match := Match{Name: "Home v Away", StartDate: "1528988400", Outcomes: test.outcomes}
+
+		arbitrage, found := FindArbitrage(match, test.allOdds)
+		if found != test.found {
+			t.Errorf("%s: found = %t, expected %t", test.name, found, test.found)
+			continue
+		}
+		if !found {
+			continue
+		}
+
+		if arbitrage.MatchID != match.ID() || !approxEqual(arbitrage.Margin, test.margin) {
+			t.Errorf("%s: %s at margin %f, expected %s at %f", test.name, arbitrage.MatchID, arbitrage.Margin, match.ID(), test.margin)
+		}
+
+		if len(arbitrage.Legs) != len(test.legs) {
+			t.Errorf("%s: legs = %+v, expected %+v", test.name, arbitrage.Legs, test.legs)
+			continue
+		}
+
+		// Every result returns the same amount
+		returned := arbitrage.Legs[0].Stake * arbitrage.Legs[0].Odds
+		for i, leg := range arbitrage.Legs {
+			expected := test.legs[i]
+			if leg.Outcome != expected.Outcome || leg.Provider != expected.Provider || leg.Odds != expected.Odds || !approxEqual(leg.Stake, expected.Stake) {
+				t.Errorf("%s: leg %d = %+v, expected %+v", test.name, i, leg, expected)
+			}
+			if !approxEqual(leg.Stake*leg.Odds, returned) {
+				t.Errorf("%s: leg %d returns %f, expected %f", test.name, i, leg.Stake*leg.Odds, returned)
+			}
+		}
+	}
+}
+
+func TestFindValue(t *testing.T) {
+	// Fair odds of 2 on both outcomes
+	sharp := []ProviderOdd{{Provider: "betfair", Odds: twoWayOdd("2", "2")}}
+
+	tests := []struct {
+		name    string
+		allOdds []ProviderOdd
+		back    []Odds
+		lay     []Odds
+		flags   []ValueFlag
+	}{
+		{
+			name:    "prices in line",
+			allOdds: sharp,
+			back:    []Odds{{Odds: 2.05}, {Odds: 1.95}},
+			lay:     []Odds{{Odds: 2.1}, {Odds: 2}},
+		},
+		{
+			name:    "backing above fair odds",
+			allOdds: sharp,
+			back:    []Odds{{Odds: 2.2}, {Odds: 1.95}},
+			lay:     []Odds{{Odds: 2.3}, {Odds: 2}},
+			flags:   []ValueFlag{{Outcome: 0, Side: SideBack, Odds: 2.2, FairOdds: 2, Edge: 0.1}},
+		},
+		{
+			name:    "laying below fair odds",
+			allOdds: sharp,
+			back:    []Odds{{Odds: 1.7}, {Odds: 1.95}},
+			lay:     []Odds{{Odds: 1.8}, {Odds: 2}},
+			flags:   []ValueFlag{{Outcome: 0, Side: SideLay, Odds: 1.8, FairOdds: 2, Edge: -0.1}},
+		},
+		{
+			name:    "both sides of different outcomes",
+			allOdds: sharp,
+			back:    []Odds{{Odds: 2.2}, {Odds: 1.6}},
+			lay:     []Odds{{Odds: 2.3}, {Odds: 1.7}},
+			flags: []ValueFlag{
+				{Outcome: 0, Side: SideBack, Odds: 2.2, FairOdds: 2, Edge: 0.1},
+				{Outcome: 1, Side: SideLay, Odds: 1.7, FairOdds: 2, Edge: -0.15},
+			},
+		},
+		{
+			name:    "only soft providers",
+			allOdds: []ProviderOdd{{Provider: "marsbet", Odds: twoWayOdd("2", "2")}},
+			back:    []Odds{{Odds: 3}, {Odds: 3}},
+			lay:     []Odds{{Odds: 3.1}, {Odds: 3.1}},
+		},
+	}
+
+	for _, test := range tests {
+		match := Match{
+			Name:      "Home v Away",
+			StartDate: "1528988400",
+			Outcomes:  2,
+			MatchOdds: &MatchOdds{
+				Back: [][]Odds{{test.back[0]}, {test.back[1]}},
+				Lay:  [][]Odds{{test.lay[0]}, {test.lay[1]}},
+			},
+		}
+
+		flags := FindValue(match, test.allOdds)
+		if len(flags) != len(test.flags) {
+			t.Errorf("%s: flags = %+v, expected %+v", test.name, flags, test.flags)
+			continue
+		}
+
+		for i, flag := range flags {
+			expected := test.flags[i]
+			if flag.MatchID != match.ID() || flag.Outcome != expected.Outcome || flag.Side != expected.Side ||
+				flag.Odds != expected.Odds || !approxEqual(flag.FairOdds, expected.FairOdds) || !approxEqual(flag.Edge, expected.Edge) {
+				t.Errorf("%s: flag %d = %+v, expected %+v", test.name, i, flag, expected)
+			}
+		}
+	}
+}
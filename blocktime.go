@@ -0,0 +1,69 @@
+package service
+
+// Number of recent blocks used to work out the average block time
+var BlockTimeWindowSize = int64(20)
+
+type BlockHeader struct {
+	Hash  string `json:"hash"`
+	Index int64  `json:"index"`
+	Time  int64  `json:"time"`
+}
+
+// BlockTimeWindow keeps the timestamps of the most recent blocks, ordered by index
+type BlockTimeWindow struct {
+	Headers []BlockHeader
+}
+
+// Add inserts a block header, dropping the oldest once the window is full
+func (w *BlockTimeWindow) Add(header BlockHeader) {
+	position := len(w.Headers)
+	for i, existing := range w.Headers {
+		if existing.Index == header.Index {
+			return
+		}
+		if existing.Index > header.Index {
+			position = i
+			break
+		}
+	}
+
+	w.Headers = append(w.Headers, BlockHeader{})
+	copy(w.Headers[position+1:], w.Headers[position:])
+	w.Headers[position] = header
+
+	if int64(len(w.Headers)) > BlockTimeWindowSize {
+		w.Headers = w.Headers[int64(len(w.Headers))-BlockTimeWindowSize:]
+	}
+}
+
+// Average returns the mean time between blocks across the window, gaps in the window are spread evenly
+func (w *BlockTimeWindow) Average() float64 {
+	if len(w.Headers) < 2 {
+		return 0
+	}
+
+	first := w.Headers[0]
+	last := w.Headers[len(w.Headers)-1]
+
+	return float64(last.Time-first.Time) / float64(last.Index-first.Index)
+}
+
+// RecordBlockTimes reads the timestamps of every new block since the last poll, up to the window size
+func (svc *Service) RecordBlockTimes(previousCount, count int64) error {
+	from := previousCount
+	if count-from > BlockTimeWindowSize {
+		from = count - BlockTimeWindowSize
+	}
+
+	// Block indexes run from 0 to count - 1
+	for index := from; index < count; index++ {
+		header, err := svc.Chain.GetBlockHeader(index)
+		if err != nil {
+			return err
+		}
+
+		svc.Internals.BlockTimes.Add(header)
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlockTimeWindow(t *testing.T) {
+	defer func(size int64) { BlockTimeWindowSize = size }(BlockTimeWindowSize)
+	BlockTimeWindowSize = 3
+
+	tests := []struct {
+		header  BlockHeader
+		indexes []int64
+		average float64
+	}{
+		{BlockHeader{Index: 10, Time: 100}, []int64{10}, 0},
+		{BlockHeader{Index: 12, Time: 130}, []int64{10, 12}, 15},
+		// Out of order blocks slot into place
+		{BlockHeader{Index: 11, Time: 110}, []int64{10, 11, 12}, 15},
+		{BlockHeader{Index: 11, Time: 999}, []int64{10, 11, 12}, 15},
+		// The oldest block drops out once the window is full
+		{BlockHeader{Index: 13, Time: 150}, []int64{11, 12, 13}, 20},
+		// Gaps are spread evenly
+		{BlockHeader{Index: 16, Time: 210}, []int64{12, 13, 16}, 20},
+	}
+
+	var window BlockTimeWindow
+	for _, test := range tests {
+		window.Add(test.header)
+
+		var indexes []int64
+		for _, header := range window.Headers {
+			indexes = append(indexes, header.Index)
+		}
+		if len(indexes) != len(test.indexes) {
+			t.Errorf("after %d: window %v, expected %v", test.header.Index, indexes, test.indexes)
+			continue
+		}
+		for i := range indexes {
+			if indexes[i] != test.indexes[i] {
+				t.Errorf("after %d: window %v, expected %v", test.header.Index, indexes, test.indexes)
+				break
+			}
+		}
+
+		if average := window.Average(); average != test.average {
+			t.Errorf("after %d: average %f, expected %f", test.header.Index, average, test.average)
+		}
+	}
+}
+
+func TestRecordBlockTimes(t *testing.T) {
+	defer func(size int64) { BlockTimeWindowSize = size }(BlockTimeWindowSize)
+	BlockTimeWindowSize = 5
+
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	chain := NewMockChain(30, 15*time.Second)
+	svc.Chain = chain
+
+	tests := []struct {
+		previous int64
+		count    int64
+		first    int64
+		last     int64
+	}{
+		// Only the window's worth of blocks is read
+		{0, 30, 25, 29},
+		{30, 32, 27, 31},
+	}
+
+	for _, test := range tests {
+		chain.Advance(test.count - chain.BestHeight())
+
+		err := svc.RecordBlockTimes(test.previous, test.count)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		headers := svc.Internals.BlockTimes.Headers
+		if len(headers) != 5 || headers[0].Index != test.first || headers[len(headers)-1].Index != test.last {
+			t.Errorf("%d to %d: window %+v, expected %d to %d", test.previous, test.count, headers, test.first, test.last)
+		}
+		if average := svc.Internals.BlockTimes.Average(); average != 15 {
+			t.Errorf("%d to %d: average %f, expected 15", test.previous, test.count, average)
+		}
+	}
+
+	// A block that can't be read stops the poll with an error
+	if err := svc.RecordBlockTimes(32, 40); err == nil {
+		t.Error("read blocks the chain doesn't have")
+	}
+}
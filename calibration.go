@@ -0,0 +1,369 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"time"
+)
+
+// PricingConfig holds the fitted coefficients of the curves used to generate markets
+type PricingConfig struct {
+	TimeScale     [4]float64 `json:"time_scale"`
+	MatchedLimit  [4]float64 `json:"matched_limit"`
+	NumOdds       [4]float64 `json:"num_odds"`
+	LayDifference [4]float64 `json:"lay_difference"`
+	WalkScale     [4]float64 `json:"walk_scale"` // Scales price walk volatility towards kickoff, not fitted
+}
+
+var DefaultPricingConfig = PricingConfig{
+	TimeScale:     [4]float64{0.9968527, 0.2166688, 71743450000, -8.774069},
+	MatchedLimit:  [4]float64{373247800000000000, 7.202931, 0.9016243, -5068},
+	NumOdds:       [4]float64{9.9308, -3.0139, 10.8597, -1.5},
+	LayDifference: [4]float64{186.2695, 4.2213, 29.5378, -0.07},
+	WalkScale:     [4]float64{1, 0.2851116, 96440480000, -19.12504},
+}
+
+// Pricing is the set of coefficients currently used by the scheduler
+var Pricing = DefaultPricingConfig
+
+// Only samples this close to full volume are used to back out the matched limit
+const minLimitTimeScale = 0.5
+
+// CalibrationSample is a single observation of a market, as recorded in redis or exported to JSON
+type CalibrationSample struct {
+	MatchID      string  `json:"match_id"`
+	Outcome      int     `json:"outcome"`
+	Scale        float64 `json:"scale"`
+	TimeTo       int64   `json:"time_to"`
+	Matched      float64 `json:"matched"`
+	FinalMatched float64 `json:"final_matched"`
+	Spread       float64 `json:"spread"`
+	Depth        int     `json:"depth"`
+	ExchangeRate float64 `json:"exchange_rate"`
+}
+
+type CurveFit struct {
+	Name         string     `json:"name"`
+	Coefficients [4]float64 `json:"coefficients"`
+	Samples      int        `json:"samples"`
+	RSquared     float64    `json:"r_squared"`
+	RMSE         float64    `json:"rmse"`
+}
+
+// LoadPricingConfig replaces the pricing coefficients with those from a fitted config file
+func LoadPricingConfig(filename string) error {
+	configJSON, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	config := DefaultPricingConfig
+	err = json.Unmarshal(configJSON, &config)
+	if err != nil {
+		return err
+	}
+
+	Pricing = config
+	return nil
+}
+
+// ReadCalibrationSamples reads samples from a JSON export
+func ReadCalibrationSamples(filename string) ([]CalibrationSample, error) {
+	samplesJSON, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []CalibrationSample
+	err = json.Unmarshal(samplesJSON, &samples)
+	return samples, err
+}
+
+// LoadCalibrationSamples builds samples from the stored match records and the recorded history of every outcome
+func (svc *Service) LoadCalibrationSamples() ([]CalibrationSample, error) {
+	matchIDs, err := svc.RedisClient.ZRange(MatchRecordsKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+
+	var samples []CalibrationSample
+	for _, matchID := range matchIDs {
+		match, err := svc.GetMatchRecord(matchID)
+		if err != nil {
+			// The record expired after the index was read
+			continue
+		}
+
+		start, err := strconv.ParseInt(match.StartDate, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		var matchSamples []CalibrationSample
+		finalMatched := float64(0)
+		for outcome := 0; outcome < match.Outcomes; outcome++ {
+			rawPoints, err := svc.RedisClient.ZRange(OddsHistoryKey(matchID, outcome), 0, -1).Result()
+			if err != nil {
+				return nil, err
+			}
+
+			for _, rawPoint := range rawPoints {
+				var point OddsPoint
+				err = json.Unmarshal([]byte(rawPoint), &point)
+				if err != nil {
+					return nil, err
+				}
+
+				// The volume curves generate the simulated volume that real fills add to
+				traded := point.Matched + point.Simulated
+
+				// Markets are suspended at kickoff, so the volume by then is final
+				if start <= now && point.Timestamp <= start {
+					finalMatched = math.Max(finalMatched, traded)
+				}
+
+				matchSamples = append(matchSamples, CalibrationSample{
+					MatchID:      matchID,
+					Outcome:      outcome,
+					Scale:        match.Scale,
+					TimeTo:       start - point.Timestamp,
+					Matched:      traded,
+					Spread:       point.Lay - point.Back,
+					Depth:        point.Depth,
+					ExchangeRate: point.ExchangeRate,
+				})
+			}
+		}
+
+		for _, sample := range matchSamples {
+			sample.FinalMatched = finalMatched
+			samples = append(samples, sample)
+		}
+	}
+
+	return samples, nil
+}
+
+// CalibratePricing fits each pricing curve to the samples by least squares, starting from the current coefficients
+func CalibratePricing(samples []CalibrationSample) (PricingConfig, []CurveFit, error) {
+	if len(samples) < 1 {
+		return Pricing, nil, errors.New("No samples to fit")
+	}
+
+	config := Pricing
+	var fits []CurveFit
+
+	// Volume is shared by every outcome, so the volume curves are fitted from the first outcome's points only
+
+	// Fraction of a match's final volume matched by a given time to kickoff
+	var xs, ys []float64
+	for _, sample := range samples {
+		if sample.Outcome == 0 && sample.FinalMatched > 0 && sample.TimeTo >= 0 {
+			xs = append(xs, float64(sample.TimeTo))
+			ys = append(ys, sample.Matched/sample.FinalMatched)
+		}
+	}
+	fit := FitCurve("time_scale", makeSigmoidal, config.TimeScale, xs, ys)
+	config.TimeScale = fit.Coefficients
+	fits = append(fits, fit)
+
+	// Volume limit backed out of matched = timeScale * limit^0.9 * exchangeRate
+	fnTimeScale := makeSigmoidal(config.TimeScale)
+	xs, ys = nil, nil
+	for _, sample := range samples {
+		timeScale := fnTimeScale(float64(sample.TimeTo))
+		if sample.Outcome != 0 || timeScale < minLimitTimeScale || sample.ExchangeRate <= 0 || sample.Matched <= 0 {
+			continue
+		}
+
+		xs = append(xs, sample.Scale)
+		ys = append(ys, math.Pow(sample.Matched/(timeScale*sample.ExchangeRate), 1/0.9))
+	}
+	fit = FitCurve("matched_limit", makeExponential, config.MatchedLimit, xs, ys)
+	config.MatchedLimit = fit.Coefficients
+	fits = append(fits, fit)
+
+	// Ladder depth is generated from numOdds * 1.5
+	xs, ys = nil, nil
+	for _, sample := range samples {
+		if sample.Depth > 0 {
+			xs = append(xs, fnTimeScale(float64(sample.TimeTo))+sample.Scale)
+			ys = append(ys, float64(sample.Depth)/1.5)
+		}
+	}
+	fit = FitCurve("num_odds", makeLogistical, config.NumOdds, xs, ys)
+	config.NumOdds = fit.Coefficients
+	fits = append(fits, fit)
+
+	// Back/lay spread is generated from half the lay difference
+	xs, ys = nil, nil
+	for _, sample := range samples {
+		if sample.Spread > 0 {
+			xs = append(xs, sample.Scale)
+			ys = append(ys, sample.Spread*2)
+		}
+	}
+	fit = FitCurve("lay_difference", makeLogistical, config.LayDifference, xs, ys)
+	config.LayDifference = fit.Coefficients
+	fits = append(fits, fit)
+
+	return config, fits, nil
+}
+
+// FitCurve fits a four coefficient curve with Levenberg-Marquardt, keeping the initial coefficients if there is too little data
+func FitCurve(name string, model func([4]float64) func(float64) float64, initial [4]float64, xs, ys []float64) CurveFit {
+	coefficients := initial
+	if len(xs) > len(initial) {
+		coefficients = levenbergMarquardt(model, initial, xs, ys)
+	}
+
+	fn := model(coefficients)
+	meanY := mean(ys)
+	residual, total := float64(0), float64(0)
+	for i, x := range xs {
+		residual += math.Pow(ys[i]-fn(x), 2)
+		total += math.Pow(ys[i]-meanY, 2)
+	}
+
+	fit := CurveFit{
+		Name:         name,
+		Coefficients: coefficients,
+		Samples:      len(xs),
+	}
+
+	if len(xs) > 0 {
+		fit.RMSE = math.Sqrt(residual / float64(len(xs)))
+	}
+	if total > 0 {
+		fit.RSquared = 1 - residual/total
+	}
+
+	return fit
+}
+
+func levenbergMarquardt(model func([4]float64) func(float64) float64, initial [4]float64, xs, ys []float64) [4]float64 {
+	coefficients := initial
+	lambda := 1e-3
+	cost := sumSquaredErrors(model(coefficients), xs, ys)
+
+	for iteration := 0; iteration < 200; iteration++ {
+		fn := model(coefficients)
+
+		// Numerical Jacobian of the model with respect to each coefficient
+		var jtj [4][4]float64
+		var jtr [4]float64
+		for i, x := range xs {
+			value := fn(x)
+			residual := ys[i] - value
+
+			var gradient [4]float64
+			for k := range coefficients {
+				step := 1e-6 * math.Max(math.Abs(coefficients[k]), 1e-6)
+				shifted := coefficients
+				shifted[k] += step
+				gradient[k] = (model(shifted)(x) - value) / step
+			}
+
+			for j := 0; j < 4; j++ {
+				jtr[j] += gradient[j] * residual
+				for k := 0; k < 4; k++ {
+					jtj[j][k] += gradient[j] * gradient[k]
+				}
+			}
+		}
+
+		improved := false
+		for attempt := 0; attempt < 10; attempt++ {
+			damped := jtj
+			for j := 0; j < 4; j++ {
+				damped[j][j] += lambda * math.Max(jtj[j][j], 1e-12)
+			}
+
+			delta, ok := solveLinear(damped, jtr)
+			if !ok {
+				lambda *= 10
+				continue
+			}
+
+			candidate := coefficients
+			for j := range candidate {
+				candidate[j] += delta[j]
+			}
+
+			candidateCost := sumSquaredErrors(model(candidate), xs, ys)
+			if candidateCost < cost {
+				converged := (cost-candidateCost)/cost < 1e-10
+				coefficients, cost = candidate, candidateCost
+				lambda /= 10
+				improved = !converged
+				break
+			}
+
+			lambda *= 10
+		}
+
+		if !improved {
+			break
+		}
+	}
+
+	return coefficients
+}
+
+func sumSquaredErrors(fn func(float64) float64, xs, ys []float64) float64 {
+	total := float64(0)
+	for i, x := range xs {
+		value := fn(x)
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return math.Inf(1)
+		}
+
+		total += math.Pow(ys[i]-value, 2)
+	}
+
+	return total
+}
+
+// solveLinear solves a 4x4 system by Gaussian elimination with partial pivoting
+func solveLinear(a [4][4]float64, b [4]float64) ([4]float64, bool) {
+	var x [4]float64
+
+	for col := 0; col < 4; col++ {
+		pivot := col
+		for row := col + 1; row < 4; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+
+		if math.Abs(a[pivot][col]) < 1e-300 {
+			return x, false
+		}
+
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+
+		for row := col + 1; row < 4; row++ {
+			factor := a[row][col] / a[col][col]
+			for k := col; k < 4; k++ {
+				a[row][k] -= factor * a[col][k]
+			}
+			b[row] -= factor * b[col]
+		}
+	}
+
+	for row := 3; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < 4; k++ {
+			sum -= a[row][k] * x[k]
+		}
+		x[row] = sum / a[row][row]
+	}
+
+	return x, true
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func addTestOddsPoint(t *testing.T, svc *Service, matchID string, outcome int, point OddsPoint) {
+	pointJSON, err := json.Marshal(point)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.RedisClient.ZAdd(OddsHistoryKey(matchID, outcome), redis.Z{Score: float64(point.Timestamp * 1000), Member: pointJSON}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadCalibrationSamples(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	now := time.Now().Unix()
+	started := Match{Name: "Started", StartDate: strconv.FormatInt(now-3600, 10), Outcomes: 2, Scale: 0.5}
+	upcoming := Match{Name: "Upcoming", StartDate: strconv.FormatInt(now+3600, 10), Outcomes: 2, Scale: 0.5}
+
+	err := svc.StoreMatchRecords(map[string]Match{started.ID(): started, upcoming.ID(): upcoming})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kickoff := now - 3600
+	addTestOddsPoint(t, svc, started.ID(), 0, OddsPoint{Timestamp: kickoff - 600, Back: 2, Lay: 2.1, Matched: 100, Simulated: 50, Depth: 3, ExchangeRate: 2})
+	addTestOddsPoint(t, svc, started.ID(), 1, OddsPoint{Timestamp: kickoff - 600, Back: 1.9, Lay: 2.05, Matched: 100, Simulated: 50, Depth: 4, ExchangeRate: 2})
+	// Points after kickoff don't change the final volume
+	addTestOddsPoint(t, svc, started.ID(), 0, OddsPoint{Timestamp: kickoff + 60, Back: 2, Lay: 2.1, Matched: 400, Simulated: 50, Depth: 3, ExchangeRate: 2})
+	addTestOddsPoint(t, svc, upcoming.ID(), 0, OddsPoint{Timestamp: now - 60, Back: 2, Lay: 2.2, Matched: 10, Simulated: 20, Depth: 5, ExchangeRate: 3})
+
+	samples, err := svc.LoadCalibrationSamples()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		matchID      string
+		outcome      int
+		timeTo       int64
+		matched      float64
+		finalMatched float64
+		depth        int
+		exchangeRate float64
+	}{
+		{started.ID(), 0, 600, 150, 150, 3, 2},
+		{started.ID(), 0, -60, 450, 150, 3, 2},
+		{started.ID(), 1, 600, 150, 150, 4, 2},
+		// Matches that haven't started have no final volume yet
+		{upcoming.ID(), 0, 3660, 30, 0, 5, 3},
+	}
+
+	if len(samples) != len(tests) {
+		t.Fatalf("%d samples, expected %d", len(samples), len(tests))
+	}
+
+	for _, test := range tests {
+		found := false
+		for _, sample := range samples {
+			if sample.MatchID != test.matchID || sample.Outcome != test.outcome || sample.TimeTo != test.timeTo {
+				continue
+			}
+
+			found = true
+			if sample.Matched != test.matched || sample.FinalMatched != test.finalMatched {
+				t.Errorf("%s outcome %d at %d: matched %f of %f, expected %f of %f", test.matchID, test.outcome, test.timeTo, sample.Matched, sample.FinalMatched, test.matched, test.finalMatched)
+			}
+			if sample.Depth != test.depth || sample.ExchangeRate != test.exchangeRate {
+				t.Errorf("%s outcome %d at %d: depth %d at rate %f, expected %d at %f", test.matchID, test.outcome, test.timeTo, sample.Depth, sample.ExchangeRate, test.depth, test.exchangeRate)
+			}
+		}
+
+		if !found {
+			t.Errorf("no sample for %s outcome %d at %d", test.matchID, test.outcome, test.timeTo)
+		}
+	}
+}
+
+func TestRecordOddsHistorySnapshotsMarket(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	svc.Internals.PriceDetails.ExchangeRate = 1.5
+	match := Match{
+		Name:      "Snapshot",
+		StartDate: strconv.FormatInt(time.Now().Unix()+3600, 10),
+		Outcomes:  2,
+		MatchOdds: &MatchOdds{
+			Back: [][]Odds{{{Odds: 2, Available: 10}, {Odds: 1.9, Available: 10}}, {{Odds: 1.8, Available: 10}}},
+			Lay:  [][]Odds{{{Odds: 2.1, Available: 10}}, {{Odds: 2, Available: 10}}},
+		},
+	}
+
+	err := svc.RecordOddsHistory(Match{}, match)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for outcome, depth := range []int{2, 1} {
+		points, err := svc.GetOddsHistory(match.ID(), outcome, time.Now().Add(-time.Minute), time.Now().Add(time.Minute), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(points) != 1 {
+			t.Fatalf("outcome %d has %d points", outcome, len(points))
+		}
+		if points[0].Depth != depth || points[0].ExchangeRate != 1.5 {
+			t.Errorf("outcome %d recorded depth %d at rate %f, expected %d at 1.5", outcome, points[0].Depth, points[0].ExchangeRate, depth)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/a-h/round"
+	"github.com/gorilla/mux"
+)
+
+type Position struct {
+	Outcome int     `json:"outcome"`
+	Side    string  `json:"side"`
+	Stake   float64 `json:"stake"`
+	Odds    float64 `json:"odds"`
+	// A stored bet to value instead, at its matched stake and fill price
+	BetID string `json:"bet_id,omitempty"`
+}
+
+type CashOut struct {
+	HedgeSide        string  `json:"hedge_side"`
+	HedgeStake       float64 `json:"hedge_stake"`
+	AverageOdds      float64 `json:"average_odds"`
+	ProfitIfWin      float64 `json:"profit_if_win"`
+	ProfitIfLose     float64 `json:"profit_if_lose"`
+	GuaranteedProfit float64 `json:"guaranteed_profit"`
+	FullyHedged      bool    `json:"fully_hedged"`
+	Levels           []Odds  `json:"levels"`
+}
+
+// GetCashOut works out the opposing bet that equalises a position's profit across outcomes,
+// taking each ladder level in turn until the hedge is complete or liquidity runs out
+func GetCashOut(position Position, matchOdds MatchOdds) (CashOut, error) {
+	if position.Stake <= 0 || position.Odds < MinOdds {
+		return CashOut{}, errors.New("Invalid position")
+	}
+
+	// A back is hedged by laying at the available lay prices and vice versa
+	var ladders [][]Odds
+	cashOut := CashOut{
+		Levels: []Odds{},
+	}
+
+	switch position.Side {
+	case SideBack:
+		cashOut.HedgeSide = SideLay
+		ladders = matchOdds.Lay
+	case SideLay:
+		cashOut.HedgeSide = SideBack
+		ladders = matchOdds.Back
+	default:
+		return CashOut{}, fmt.Errorf("Unknown position side: %s", position.Side)
+	}
+
+	if position.Outcome < 0 || position.Outcome >= len(ladders) {
+		return CashOut{}, fmt.Errorf("Invalid outcome %d", position.Outcome)
+	}
+
+	// Profit is equal on both results once the hedge stakes multiplied by their odds cover the position's return
+	target := position.Stake * position.Odds
+	covered := float64(0)
+	hedgeStake := float64(0)
+
+	for _, level := range ladders[position.Outcome] {
+		if covered >= target || level.Odds <= 0 {
+			break
+		}
+
+		stake := math.Min(level.Available, (target-covered)/level.Odds)
+		if stake <= 0 {
+			continue
+		}
+
+		covered += stake * level.Odds
+		hedgeStake += stake
+
+		cashOut.Levels = append(cashOut.Levels, Odds{
+			Odds:      level.Odds,
+			Available: round.AwayFromZero(stake, 2),
+		})
+	}
+
+	if hedgeStake > 0 {
+		cashOut.AverageOdds = round.AwayFromZero(covered/hedgeStake, 2)
+	}
+
+	// Profit for the position holder if the outcome wins or loses
+	if position.Side == SideBack {
+		cashOut.ProfitIfWin = position.Stake*(position.Odds-1) - (covered - hedgeStake)
+		cashOut.ProfitIfLose = -position.Stake + hedgeStake
+	} else {
+		cashOut.ProfitIfWin = -position.Stake*(position.Odds-1) + (covered - hedgeStake)
+		cashOut.ProfitIfLose = position.Stake - hedgeStake
+	}
+
+	cashOut.HedgeStake = round.AwayFromZero(hedgeStake, 2)
+	cashOut.ProfitIfWin = round.AwayFromZero(cashOut.ProfitIfWin, 2)
+	cashOut.ProfitIfLose = round.AwayFromZero(cashOut.ProfitIfLose, 2)
+	cashOut.GuaranteedProfit = math.Min(cashOut.ProfitIfWin, cashOut.ProfitIfLose)
+	cashOut.FullyHedged = target-covered < minStake
+
+	return cashOut, nil
+}
+
+// GetMatchCashOut values a position against a match's current ladders
+func (svc *Service) GetMatchCashOut(matchID string, position Position) (CashOut, error) {
+	match, err := svc.GetMatch(matchID)
+	if err != nil {
+		return CashOut{}, err
+	}
+
+	if match.MatchOdds == nil {
+		return CashOut{}, errors.New("No prices available")
+	}
+
+	return GetCashOut(position, *match.MatchOdds)
+}
+
+func (svc *Service) CashOutHandler(w http.ResponseWriter, r *http.Request) {
+	var position Position
+	err := json.NewDecoder(r.Body).Decode(&position)
+	if err != nil {
+		http.Error(w, "Invalid position", http.StatusBadRequest)
+		return
+	}
+
+	matchID := mux.Vars(r)["id"]
+
+	if position.BetID != "" {
+		var bet Bet
+		err = svc.GetRedis(BetKey(position.BetID), &bet)
+		if err != nil || bet.MatchID != matchID {
+			http.Error(w, "Bet not found", http.StatusNotFound)
+			return
+		}
+
+		position = bet.Position()
+	}
+
+	cashOut, err := svc.GetMatchCashOut(matchID, position)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(cashOut)
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestGetCashOut(t *testing.T) {
+	tests := []struct {
+		name      string
+		position  Position
+		matchOdds MatchOdds
+		cashOut   CashOut
+		err       bool
+	}{
+		{
+			name:      "back hedged at one level",
+			position:  Position{Outcome: 0, Side: SideBack, Stake: 10, Odds: 3},
+			matchOdds: MatchOdds{Lay: [][]Odds{{{Odds: 2, Available: 100}}}},
+			cashOut: CashOut{
+				HedgeSide: SideLay, HedgeStake: 15, AverageOdds: 2,
+				ProfitIfWin: 5, ProfitIfLose: 5, GuaranteedProfit: 5, FullyHedged: true,
+				Levels: []Odds{{Odds: 2, Available: 15}},
+			},
+		},
+		{
+			name:      "back hedged across levels",
+			position:  Position{Outcome: 0, Side: SideBack, Stake: 10, Odds: 3},
+			matchOdds: MatchOdds{Lay: [][]Odds{{{Odds: 2, Available: 5}, {Odds: 2.5, Available: 100}}}},
+			cashOut: CashOut{
+				HedgeSide: SideLay, HedgeStake: 13, AverageOdds: 2.31,
+				ProfitIfWin: 3, ProfitIfLose: 3, GuaranteedProfit: 3, FullyHedged: true,
+				Levels: []Odds{{Odds: 2, Available: 5}, {Odds: 2.5, Available: 8}},
+			},
+		},
+		{
+			name:      "back partly hedged when liquidity runs out",
+			position:  Position{Outcome: 0, Side: SideBack, Stake: 10, Odds: 3},
+			matchOdds: MatchOdds{Lay: [][]Odds{{{Odds: 2, Available: 5}}}},
+			cashOut: CashOut{
+				HedgeSide: SideLay, HedgeStake: 5, AverageOdds: 2,
+				ProfitIfWin: 15, ProfitIfLose: -5, GuaranteedProfit: -5, FullyHedged: false,
+				Levels: []Odds{{Odds: 2, Available: 5}},
+			},
+		},
+		{
+			name:      "lay hedged by backing",
+			position:  Position{Outcome: 1, Side: SideLay, Stake: 10, Odds: 3},
+			matchOdds: MatchOdds{Back: [][]Odds{{}, {{Odds: 4, Available: 100}}}},
+			cashOut: CashOut{
+				HedgeSide: SideBack, HedgeStake: 7.5, AverageOdds: 4,
+				ProfitIfWin: 2.5, ProfitIfLose: 2.5, GuaranteedProfit: 2.5, FullyHedged: true,
+				Levels: []Odds{{Odds: 4, Available: 7.5}},
+			},
+		},
+		{
+			name:      "no liquidity",
+			position:  Position{Outcome: 0, Side: SideBack, Stake: 10, Odds: 3},
+			matchOdds: MatchOdds{Lay: [][]Odds{{}}},
+			cashOut: CashOut{
+				HedgeSide: SideLay, ProfitIfWin: 20, ProfitIfLose: -10, GuaranteedProfit: -10,
+				Levels: []Odds{},
+			},
+		},
+		{
+			name:     "invalid stake",
+			position: Position{Outcome: 0, Side: SideBack, Stake: 0, Odds: 3},
+			err:      true,
+		},
+		{
+			name:     "unknown side",
+			position: Position{Outcome: 0, Side: "both", Stake: 10, Odds: 3},
+			err:      true,
+		},
+		{
+			name:      "outcome without a ladder",
+			position:  Position{Outcome: 2, Side: SideBack, Stake: 10, Odds: 3},
+			matchOdds: MatchOdds{Lay: [][]Odds{{}, {}}},
+			err:       true,
+		},
+	}
+
+	for _, test := range tests {
+		cashOut, err := GetCashOut(test.position, test.matchOdds)
+		if (err != nil) != test.err {
+			t.Errorf("%s: error = %v", test.name, err)
+			continue
+		}
+
+		if test.err {
+			continue
+		}
+
+		expected := test.cashOut
+		if cashOut.HedgeSide != expected.HedgeSide ||
+			!approxEqual(cashOut.HedgeStake, expected.HedgeStake) ||
+			!approxEqual(cashOut.AverageOdds, expected.AverageOdds) ||
+			!approxEqual(cashOut.ProfitIfWin, expected.ProfitIfWin) ||
+			!approxEqual(cashOut.ProfitIfLose, expected.ProfitIfLose) ||
+			!approxEqual(cashOut.GuaranteedProfit, expected.GuaranteedProfit) ||
+			cashOut.FullyHedged != expected.FullyHedged {
+			t.Errorf("%s: cash out = %+v, expected %+v", test.name, cashOut, expected)
+		}
+
+		if len(cashOut.Levels) != len(expected.Levels) {
+			t.Errorf("%s: levels = %v, expected %v", test.name, cashOut.Levels, expected.Levels)
+			continue
+		}
+
+		for i, level := range cashOut.Levels {
+			if !approxEqual(level.Odds, expected.Levels[i].Odds) || !approxEqual(level.Available, expected.Levels[i].Available) {
+				t.Errorf("%s: level %d = %v, expected %v", test.name, i, level, expected.Levels[i])
+			}
+		}
+	}
+}
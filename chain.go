@@ -0,0 +1,58 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ChainClient is the blockchain backend the scheduler reads from, NodePool is the NEO implementation
+type ChainClient interface {
+	// GetBlockCount returns the number of blocks on the current node
+	GetBlockCount() (int64, error)
+	GetBlockHeader(index int64) (BlockHeader, error)
+	// BestHeight returns the highest block count seen on any node
+	BestHeight() int64
+	// Refresh rechecks the backend's nodes and reselects the best one
+	Refresh()
+	// State reports the backend's sources for the status endpoint
+	State() ChainState
+}
+
+// ChainState describes a backend's sources and the one currently in use
+type ChainState struct {
+	Backend string `json:"backend"`
+	Current string `json:"current"`
+	Height  int64  `json:"height"`
+	Nodes   []Node `json:"nodes"`
+}
+
+// ErrNoRPC is returned by NEO features when the chain backend can't make JSON-RPC calls
+var ErrNoRPC = errors.New("Chain backend does not support RPC calls")
+
+// RPC returns the chain backend's JSON-RPC caller for the features that read NEO contracts and accounts
+func (svc *Service) RPC() (RPCCaller, error) {
+	rpc, ok := svc.Chain.(RPCCaller)
+	if !ok {
+		return nil, ErrNoRPC
+	}
+
+	return rpc, nil
+}
+
+// ProbeNodes refreshes the health of every node and reselects the best one
+func (svc *Service) ProbeNodes() {
+	previous := svc.Chain.State().Current
+
+	svc.Chain.Refresh()
+
+	state := svc.Chain.State()
+	if state.Current != "" && state.Current != previous {
+		svc.Logger.Log("msg", fmt.Sprintf("Selected node %s at height %d", state.Current, svc.Chain.BestHeight()))
+	}
+}
+
+func (svc *Service) NodePoolHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(svc.Chain.State())
+}
@@ -3,17 +3,18 @@ package main
 import (
 	"context"
 	"encoding/csv"
+	"flag"
 	"fmt"
 	stdlog "log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/go-redis/redis"
 
-	"github.com/CityOfZion/neo-go-sdk/neo"
 	"github.com/blocksports/block-sports-scheduler"
 	"github.com/go-kit/kit/log"
 	"github.com/pusher/pusher-http-go"
@@ -44,12 +45,37 @@ func main() {
 		MaxRetries:  3,
 	})
 
+	/*
+		Run subcommands
+	*/
+
+	if len(os.Args) > 1 && os.Args[1] == "fit" {
+		err := fit(logger, redisClient, os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	_, err := redisClient.Ping().Result()
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
+	/*
+		Load fitted pricing coefficients
+	*/
+
+	if pricingConfig := os.Getenv("PRICING_CONFIG"); pricingConfig != "" {
+		err = service.LoadPricingConfig(pricingConfig)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
 	/*
 		Enable pusher client
 	*/
@@ -66,33 +92,62 @@ func main() {
 	pusherClient.HttpClient = httpClient
 
 	/*
-		Load in node uris and create new Neo client
+		Create publisher, the hub serves WebSocket and SSE clients directly
 	*/
 
-	file, err := os.Open("node_uris.csv")
-	if err != nil {
-		fmt.Println(err)
-		return
+	publishers := os.Getenv("PUBLISHERS")
+	if publishers == "" {
+		publishers = "pusher"
 	}
 
-	csvR := csv.NewReader(file)
-	nodeURIs, err := csvR.Read()
-	if err != nil {
-		fmt.Println(err)
-		return
+	var hub *service.Hub
+	if strings.Contains(publishers, "hub") {
+		hub = service.NewHub()
 	}
 
-	neoClient, err := neo.NewClientUsingMultipleNodes(nodeURIs)
+	publishBackends, err := service.NewPublishers(publishers, &pusherClient, hub)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
+	/*
+		Create chain backend, the mock chain runs without any nodes
+	*/
+
+	var chain service.ChainClient
+	if os.Getenv("CHAIN_BACKEND") == "mock" {
+		mockChain := service.NewMockChain(0, 15*time.Second)
+		go func() {
+			for range time.Tick(15 * time.Second) {
+				mockChain.Advance(1)
+			}
+		}()
+		chain = mockChain
+	} else {
+		file, err := os.Open("node_uris.csv")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		csvR := csv.NewReader(file)
+		nodeURIs, err := csvR.Read()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		nodePool := service.NewNodePool(nodeURIs)
+		nodePool.Refresh()
+		chain = nodePool
+	}
+
 	/*
 		Initialise service
 	*/
 
-	svc := service.NewService(logger, redisClient, &pusherClient, neoClient)
+	svc := service.NewService(logger, redisClient, &pusherClient, publishBackends, hub, chain)
 
 	/*
 		Create healthcheck web service
@@ -134,3 +189,42 @@ func shutdown(svc *service.Service) <-chan struct{} {
 	}()
 	return end
 }
+
+// fit calibrates the pricing curves from recorded history and writes a config the scheduler can load
+func fit(logger log.Logger, redisClient *redis.Client, args []string) error {
+	flags := flag.NewFlagSet("fit", flag.ExitOnError)
+	source := flags.String("source", "redis", "Where to read history from, redis or json")
+	input := flags.String("input", "", "JSON export of calibration samples when source is json")
+	output := flags.String("output", "pricing", "Config file to write, saved as <output>.json")
+	flags.Parse(args)
+
+	var samples []service.CalibrationSample
+	var err error
+
+	switch *source {
+	case "redis":
+		svc := &service.Service{
+			Logger:      logger,
+			RedisClient: redisClient,
+		}
+		samples, err = svc.LoadCalibrationSamples()
+	case "json":
+		samples, err = service.ReadCalibrationSamples(*input)
+	default:
+		err = fmt.Errorf("Unknown source: %s", *source)
+	}
+	if err != nil {
+		return err
+	}
+
+	config, fits, err := service.CalibratePricing(samples)
+	if err != nil {
+		return err
+	}
+
+	for _, curve := range fits {
+		fmt.Printf("%-15s n=%-6d r2=%-10.4f rmse=%-12.4g %v\n", curve.Name, curve.Samples, curve.RSquared, curve.RMSE, curve.Coefficients)
+	}
+
+	return service.WriteDataToJSONFile(*output, config)
+}
@@ -0,0 +1,394 @@
+package service
+
+import (
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Consensus strategies used to combine provider prices into a single book
+const (
+	ConsensusMean     = "mean"
+	ConsensusWeighted = "weighted"
+	ConsensusMedian   = "median"
+	ConsensusTrimmed  = "trimmed"
+)
+
+// ConsensusStrategy selects how provider prices are combined, defaults to a sharpness weighted mean
+var ConsensusStrategy = os.Getenv("CONSENSUS_STRATEGY")
+
+// Number of scaled MADs a provider can sit from the median before it is rejected
+var OutlierThreshold = 3.0
+
+// Fraction of providers dropped from each end of the book by the trimmed mean
+var TrimFraction = 0.1
+
+// ProviderSharpness weights each provider by how closely its prices track the true market, every other
+// provider is still used at DefaultSharpness, it is the source of OddsSources so the two lists can't drift apart
+var ProviderSharpness = map[string]float64{
+	"betfair":     1.0,
+	"sbobet":      0.95,
+	"marathonbet": 0.9,
+	"bet365":      0.85,
+	"ysb88":       0.8,
+	"10bet":       0.7,
+	"williamhill": 0.7,
+	"bwin":        0.65,
+	"betsson":     0.65,
+	"1xbet":       0.6,
+	"intertops":   0.6,
+	"interwetten": 0.55,
+	"betclic":     0.55,
+	"betfred":     0.5,
+	"skybet":      0.5,
+	"marsbet":     0.4,
+}
+
+// Weight given to providers that aren't listed in ProviderSharpness
+var DefaultSharpness = 0.3
+
+type ProviderOdd struct {
+	Provider string      `json:"provider"`
+	Odds     ThreeWayOdd `json:"odds"`
+}
+
+type Consensus struct {
+	Strategy      string           `json:"strategy"`
+	Providers     []ProviderWeight `json:"providers"`
+	Probabilities []float64        `json:"probabilities"`
+}
+
+// ProviderWeight is the share of the consensus taken from a provider, Weight is the mean of its per outcome weights
+type ProviderWeight struct {
+	Provider       string    `json:"provider"`
+	Weight         float64   `json:"weight"`
+	OutcomeWeights []float64 `json:"outcome_weights"`
+}
+
+// providerBook holds a provider's margin-free probabilities for each outcome
+type providerBook struct {
+	provider      string
+	probabilities []float64
+}
+
+// sourceNames lists the providers in ProviderSharpness, sharpest first
+func sourceNames(sharpness map[string]float64) []string {
+	var names []string
+	for name := range sharpness {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if sharpness[names[i]] != sharpness[names[j]] {
+			return sharpness[names[i]] > sharpness[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	return names
+}
+
+// GetConsensusProbabilities combines every provider's margin-free probabilities using the configured strategy
+func GetConsensusProbabilities(allOdds []ProviderOdd, numOutcomes int) ([]float64, Consensus) {
+	strategy := ConsensusStrategy
+	if strategy == "" {
+		strategy = ConsensusWeighted
+	}
+
+	consensus := Consensus{
+		Strategy:  strategy,
+		Providers: []ProviderWeight{},
+	}
+
+	books := makeProviderBooks(allOdds, numOutcomes)
+	if len(books) < 1 {
+		return nil, consensus
+	}
+
+	var weights [][]float64
+	switch strategy {
+	case ConsensusMean:
+		weights = equalWeights(books, numOutcomes)
+	case ConsensusMedian:
+		weights = medianWeights(books, numOutcomes)
+	case ConsensusTrimmed:
+		weights = trimmedWeights(books, numOutcomes)
+	default:
+		consensus.Strategy = ConsensusWeighted
+		weights = sharpnessWeights(books, numOutcomes)
+	}
+
+	probabilities := normaliseProbabilities(combineProbabilities(books, weights))
+	consensus.Probabilities = probabilities
+
+	for j, book := range books {
+		consensus.Providers = append(consensus.Providers, ProviderWeight{
+			Provider:       book.provider,
+			Weight:         mean(weights[j]),
+			OutcomeWeights: weights[j],
+		})
+	}
+
+	return probabilities, consensus
+}
+
+// makeProviderBooks converts each provider's prices into margin-free probabilities, skipping incomplete books
+func makeProviderBooks(allOdds []ProviderOdd, numOutcomes int) []providerBook {
+	var books []providerBook
+
+	for _, odds := range allOdds {
+		probabilities := make([]float64, numOutcomes)
+		complete := true
+
+		for i := 0; i < numOutcomes; i++ {
+			price, err := strconv.ParseFloat(odds.Odds.GetOutcome(i), 32)
+			if err != nil || price <= 1 {
+				complete = false
+				break
+			}
+
+			probabilities[i] = 1 / price
+		}
+
+		if !complete {
+			continue
+		}
+
+		books = append(books, providerBook{
+			provider:      odds.Provider,
+			probabilities: normaliseProbabilities(probabilities),
+		})
+	}
+
+	return books
+}
+
+// combineProbabilities sums each outcome's probabilities using the weight given to every book
+func combineProbabilities(books []providerBook, weights [][]float64) []float64 {
+	probabilities := make([]float64, len(books[0].probabilities))
+	for j, book := range books {
+		for i, p := range book.probabilities {
+			probabilities[i] += p * weights[j][i]
+		}
+	}
+
+	return probabilities
+}
+
+// makeWeights allocates a weight for every book and outcome
+func makeWeights(books []providerBook, numOutcomes int) [][]float64 {
+	weights := make([][]float64, len(books))
+	for j := range weights {
+		weights[j] = make([]float64, numOutcomes)
+	}
+
+	return weights
+}
+
+// normaliseWeights scales each outcome's weights so they sum to 1
+func normaliseWeights(weights [][]float64, numOutcomes int) [][]float64 {
+	for i := 0; i < numOutcomes; i++ {
+		total := float64(0)
+		for j := range weights {
+			total += weights[j][i]
+		}
+
+		if total == 0 {
+			continue
+		}
+
+		for j := range weights {
+			weights[j][i] /= total
+		}
+	}
+
+	return weights
+}
+
+func equalWeights(books []providerBook, numOutcomes int) [][]float64 {
+	weights := makeWeights(books, numOutcomes)
+	for j := range books {
+		for i := 0; i < numOutcomes; i++ {
+			weights[j][i] = 1
+		}
+	}
+
+	return normaliseWeights(weights, numOutcomes)
+}
+
+func sharpnessWeights(books []providerBook, numOutcomes int) [][]float64 {
+	weights := makeWeights(books, numOutcomes)
+	for j, book := range books {
+		sharpness := getSharpness(book.provider)
+		for i := 0; i < numOutcomes; i++ {
+			weights[j][i] = sharpness
+		}
+	}
+
+	return normaliseWeights(weights, numOutcomes)
+}
+
+// medianWeights gives each outcome's median provider all the weight, or splits it between the middle two
+func medianWeights(books []providerBook, numOutcomes int) [][]float64 {
+	weights := makeWeights(books, numOutcomes)
+
+	for i := 0; i < numOutcomes; i++ {
+		order := sortedBooks(books, i)
+		middle := len(order) / 2
+
+		if len(order)%2 == 0 {
+			weights[order[middle-1]][i] = 0.5
+			weights[order[middle]][i] = 0.5
+		} else {
+			weights[order[middle]][i] = 1
+		}
+	}
+
+	return weights
+}
+
+// trimmedWeights drops outlying providers then shares each outcome's weight between the providers left after trimming both ends
+func trimmedWeights(books []providerBook, numOutcomes int) [][]float64 {
+	weights := makeWeights(books, numOutcomes)
+	rejected := rejectOutliers(books, numOutcomes)
+
+	var kept []int
+	for j := range books {
+		if !rejected[j] {
+			kept = append(kept, j)
+		}
+	}
+
+	for i := 0; i < numOutcomes; i++ {
+		order := make([]int, len(kept))
+		copy(order, kept)
+		sort.SliceStable(order, func(a, b int) bool {
+			return books[order[a]].probabilities[i] < books[order[b]].probabilities[i]
+		})
+
+		trim := int(float64(len(order)) * TrimFraction)
+		for _, j := range order[trim : len(order)-trim] {
+			weights[j][i] = 1
+		}
+	}
+
+	return normaliseWeights(weights, numOutcomes)
+}
+
+// sortedBooks returns book indexes ordered by their probability for an outcome
+func sortedBooks(books []providerBook, outcome int) []int {
+	order := make([]int, len(books))
+	for j := range order {
+		order[j] = j
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		return books[order[a]].probabilities[outcome] < books[order[b]].probabilities[outcome]
+	})
+
+	return order
+}
+
+// rejectOutliers flags any provider whose probability for an outcome is too far from the median, as measured by MAD
+func rejectOutliers(books []providerBook, numOutcomes int) []bool {
+	rejected := make([]bool, len(books))
+
+	for i := 0; i < numOutcomes; i++ {
+		values := outcomeProbabilities(books, i)
+		centre := median(values)
+
+		deviations := make([]float64, len(values))
+		for j, value := range values {
+			deviations[j] = math.Abs(value - centre)
+		}
+
+		// Scale MAD so it is comparable to a standard deviation for normally distributed prices
+		mad := median(deviations) * 1.4826
+		if mad == 0 {
+			continue
+		}
+
+		for j, deviation := range deviations {
+			if deviation/mad > OutlierThreshold {
+				rejected[j] = true
+			}
+		}
+	}
+
+	for _, reject := range rejected {
+		if !reject {
+			return rejected
+		}
+	}
+
+	// Keep everyone rather than leave no book at all
+	return make([]bool, len(books))
+}
+
+func outcomeProbabilities(books []providerBook, outcome int) []float64 {
+	values := make([]float64, len(books))
+	for j, book := range books {
+		values[j] = book.probabilities[outcome]
+	}
+
+	return values
+}
+
+// normaliseProbabilities scales probabilities so they sum to 1, removing the bookmaker's margin
+func normaliseProbabilities(probabilities []float64) []float64 {
+	total := float64(0)
+	for _, p := range probabilities {
+		total += p
+	}
+
+	if total == 0 {
+		return probabilities
+	}
+
+	normalised := make([]float64, len(probabilities))
+	for i, p := range probabilities {
+		normalised[i] = p / total
+	}
+
+	return normalised
+}
+
+func getSharpness(provider string) float64 {
+	if sharpness, ok := ProviderSharpness[strings.ToLower(provider)]; ok {
+		return sharpness
+	}
+
+	return DefaultSharpness
+}
+
+func mean(values []float64) float64 {
+	if len(values) < 1 {
+		return 0
+	}
+
+	total := float64(0)
+	for _, value := range values {
+		total += value
+	}
+
+	return total / float64(len(values))
+}
+
+func median(values []float64) float64 {
+	if len(values) < 1 {
+		return 0
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+
+	return sorted[middle]
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"math"
+	"testing"
+)
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func twoWayOdd(home, away string) ThreeWayOdd {
+	return ThreeWayOdd{HomeOdds: home, AwayOdds: away}
+}
+
+func TestGetConsensusProbabilities(t *testing.T) {
+	allOdds := []ProviderOdd{
+		{Provider: "betfair", Odds: twoWayOdd("2", "2")},
+		{Provider: "bet365", Odds: twoWayOdd("4", "1.3333333333333333")},
+		{Provider: "marsbet", Odds: twoWayOdd("1.25", "5")},
+	}
+
+	tests := []struct {
+		strategy      string
+		probabilities []float64
+		weights       []float64
+	}{
+		{
+			strategy:      ConsensusMean,
+			probabilities: []float64{(0.5 + 0.25 + 0.8) / 3, (0.5 + 0.75 + 0.2) / 3},
+			weights:       []float64{1.0 / 3, 1.0 / 3, 1.0 / 3},
+		},
+		{
+			strategy:      ConsensusWeighted,
+			probabilities: []float64{(0.5*1 + 0.25*0.85 + 0.8*0.4) / 2.25, (0.5*1 + 0.75*0.85 + 0.2*0.4) / 2.25},
+			weights:       []float64{1 / 2.25, 0.85 / 2.25, 0.4 / 2.25},
+		},
+		{
+			strategy:      ConsensusMedian,
+			probabilities: []float64{0.5, 0.5},
+			weights:       []float64{1, 0, 0},
+		},
+	}
+
+	for _, test := range tests {
+		ConsensusStrategy = test.strategy
+		probabilities, consensus := GetConsensusProbabilities(allOdds, 2)
+
+		if consensus.Strategy != test.strategy {
+			t.Errorf("%s: strategy = %s", test.strategy, consensus.Strategy)
+		}
+
+		for i, expected := range test.probabilities {
+			if !approxEqual(probabilities[i], expected) {
+				t.Errorf("%s: probability %d = %f, expected %f", test.strategy, i, probabilities[i], expected)
+			}
+		}
+
+		for j, expected := range test.weights {
+			if !approxEqual(consensus.Providers[j].Weight, expected) {
+				t.Errorf("%s: %s weight = %f, expected %f", test.strategy, consensus.Providers[j].Provider, consensus.Providers[j].Weight, expected)
+			}
+		}
+	}
+
+	ConsensusStrategy = ""
+}
+
+func TestMedianWeightsPerOutcome(t *testing.T) {
+	books := []providerBook{
+		{provider: "a", probabilities: []float64{0.2, 0.8}},
+		{provider: "b", probabilities: []float64{0.4, 0.6}},
+		{provider: "c", probabilities: []float64{0.5, 0.5}},
+		{provider: "d", probabilities: []float64{0.3, 0.7}},
+	}
+
+	tests := []struct {
+		provider string
+		weights  []float64
+	}{
+		{"a", []float64{0, 0}},
+		{"b", []float64{0.5, 0.5}},
+		{"c", []float64{0, 0}},
+		{"d", []float64{0.5, 0.5}},
+	}
+
+	weights := medianWeights(books, 2)
+	for j, test := range tests {
+		for i, expected := range test.weights {
+			if !approxEqual(weights[j][i], expected) {
+				t.Errorf("%s outcome %d weight = %f, expected %f", test.provider, i, weights[j][i], expected)
+			}
+		}
+	}
+}
+
+func TestTrimmedWeights(t *testing.T) {
+	defer func(fraction float64) { TrimFraction = fraction }(TrimFraction)
+	TrimFraction = 0.2
+
+	books := []providerBook{
+		{provider: "a", probabilities: []float64{0.50, 0.50}},
+		{provider: "b", probabilities: []float64{0.51, 0.49}},
+		{provider: "c", probabilities: []float64{0.49, 0.51}},
+		{provider: "d", probabilities: []float64{0.52, 0.48}},
+		{provider: "e", probabilities: []float64{0.48, 0.52}},
+		{provider: "outlier", probabilities: []float64{0.95, 0.05}},
+	}
+
+	weights := trimmedWeights(books, 2)
+
+	tests := []struct {
+		provider string
+		weights  []float64
+	}{
+		// Five books are kept after rejecting the outlier, one is trimmed from each end
+		{"a", []float64{1.0 / 3, 1.0 / 3}},
+		{"b", []float64{1.0 / 3, 1.0 / 3}},
+		{"c", []float64{1.0 / 3, 1.0 / 3}},
+		{"d", []float64{0, 0}},
+		{"e", []float64{0, 0}},
+		{"outlier", []float64{0, 0}},
+	}
+
+	for j, test := range tests {
+		for i, expected := range test.weights {
+			if !approxEqual(weights[j][i], expected) {
+				t.Errorf("%s outcome %d weight = %f, expected %f", test.provider, i, weights[j][i], expected)
+			}
+		}
+	}
+}
+
+func TestRejectOutliersKeepsEveryoneWhenAllRejected(t *testing.T) {
+	books := []providerBook{
+		{provider: "a", probabilities: []float64{0.1, 0.9}},
+	}
+
+	rejected := rejectOutliers(books, 2)
+	if rejected[0] {
+		t.Error("the only book was rejected")
+	}
+}
+
+func TestUnlistedProvidersAreDownWeighted(t *testing.T) {
+	allOdds := []ProviderOdd{
+		{Provider: "betfair", Odds: twoWayOdd("2", "2")},
+		{Provider: "ladbrokes", Odds: twoWayOdd("4", "1.3333333333333333")},
+	}
+
+	defer func(strategy string) { ConsensusStrategy = strategy }(ConsensusStrategy)
+	ConsensusStrategy = ConsensusWeighted
+
+	probabilities, consensus := GetConsensusProbabilities(allOdds, 2)
+
+	total := 1 + DefaultSharpness
+	expected := []float64{(0.5 + 0.25*DefaultSharpness) / total, (0.5 + 0.75*DefaultSharpness) / total}
+	for i := range expected {
+		if !approxEqual(probabilities[i], expected[i]) {
+			t.Errorf("outcome %d = %f, expected %f", i, probabilities[i], expected[i])
+		}
+	}
+
+	if len(consensus.Providers) != 2 || !approxEqual(consensus.Providers[1].Weight, DefaultSharpness/total) {
+		t.Errorf("providers %+v, expected ladbrokes at %f", consensus.Providers, DefaultSharpness/total)
+	}
+}
+
+func TestOddsSources(t *testing.T) {
+	if len(OddsSources) != len(ProviderSharpness) {
+		t.Fatalf("OddsSources has %d providers, ProviderSharpness has %d", len(OddsSources), len(ProviderSharpness))
+	}
+
+	for i := 1; i < len(OddsSources); i++ {
+		if ProviderSharpness[OddsSources[i-1]] < ProviderSharpness[OddsSources[i]] {
+			t.Errorf("%s listed before sharper %s", OddsSources[i-1], OddsSources[i])
+		}
+	}
+}
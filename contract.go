@@ -0,0 +1,354 @@
+package service
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis"
+)
+
+// Notification names emitted by the betting contract
+const (
+	BetPlacedEvent  = "bet_placed"
+	BetMatchedEvent = "bet_matched"
+	BetSettledEvent = "bet_settled"
+)
+
+// Script hash of the betting contract, the watcher is disabled when empty
+var BettingContractHash = os.Getenv("BETTING_CONTRACT_HASH")
+
+// Maximum number of blocks scanned in one run so a long catch up doesn't hold up the scheduler
+var ContractScanBatch = int64(50)
+
+// Number of events kept per match
+var ContractEventHistory = int64(500)
+
+var contractMutex = &sync.Mutex{}
+
+// RPCCaller makes JSON-RPC calls against a NEO node, the node pool or a fake node in tests
+type RPCCaller interface {
+	Call(method string, params []interface{}, result interface{}) error
+}
+
+type Block struct {
+	Hash         string        `json:"hash"`
+	Index        int64         `json:"index"`
+	Time         int64         `json:"time"`
+	Transactions []Transaction `json:"tx"`
+}
+
+type Transaction struct {
+	TxID string `json:"txid"`
+	Type string `json:"type"`
+}
+
+type ApplicationLog struct {
+	TxID          string         `json:"txid"`
+	Executions    []Execution    `json:"executions"`
+	Notifications []Notification `json:"notifications"`
+}
+
+type Execution struct {
+	VMState       string         `json:"vmstate"`
+	Notifications []Notification `json:"notifications"`
+}
+
+type Notification struct {
+	Contract string    `json:"contract"`
+	State    StackItem `json:"state"`
+}
+
+type StackItem struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// ContractEvent is a decoded betting contract notification
+type ContractEvent struct {
+	Type       string `json:"type"`
+	TxID       string `json:"txid"`
+	BlockIndex int64  `json:"block_index"`
+	BlockTime  int64  `json:"block_time"`
+	MatchID    string `json:"match_id"`
+	BetID      string `json:"bet_id"`
+	Account    string `json:"account,omitempty"`
+	Outcome    int64  `json:"outcome"`
+	Side       string `json:"side,omitempty"`
+	Odds       int64  `json:"odds,omitempty"`
+	Stake      int64  `json:"stake,omitempty"`
+	Result     string `json:"result,omitempty"`
+}
+
+// ContractWatcher finds and decodes the betting contract's notifications in each block
+type ContractWatcher struct {
+	RPC        RPCCaller
+	ScriptHash string
+}
+
+func NewContractWatcher(rpc RPCCaller, scriptHash string) *ContractWatcher {
+	return &ContractWatcher{
+		RPC:        rpc,
+		ScriptHash: normaliseScriptHash(scriptHash),
+	}
+}
+
+// ScanBlock returns every betting contract event raised by a block's invocation transactions
+func (w *ContractWatcher) ScanBlock(index int64) ([]ContractEvent, error) {
+	var block Block
+	err := w.RPC.Call("getblock", []interface{}{index, 1}, &block)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []ContractEvent
+	for _, tx := range block.Transactions {
+		if tx.Type != "InvocationTransaction" {
+			continue
+		}
+
+		var log ApplicationLog
+		err = w.RPC.Call("getapplicationlog", []interface{}{tx.TxID}, &log)
+		if err != nil {
+			return nil, err
+		}
+
+		// Older nodes return notifications at the top level rather than per execution
+		notifications := log.Notifications
+		for _, execution := range log.Executions {
+			if strings.Contains(execution.VMState, "FAULT") {
+				continue
+			}
+			notifications = append(notifications, execution.Notifications...)
+		}
+
+		for _, notification := range notifications {
+			if normaliseScriptHash(notification.Contract) != w.ScriptHash {
+				continue
+			}
+
+			event, err := DecodeNotification(notification)
+			if err != nil {
+				continue
+			}
+
+			event.TxID = tx.TxID
+			event.BlockIndex = block.Index
+			event.BlockTime = block.Time
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+// DecodeNotification turns a notification's state array into a typed event,
+// the first item is the event name followed by the match and bet IDs
+func DecodeNotification(notification Notification) (ContractEvent, error) {
+	var items []StackItem
+	err := json.Unmarshal(notification.State.Value, &items)
+	if err != nil {
+		return ContractEvent{}, err
+	}
+
+	if len(items) < 3 {
+		return ContractEvent{}, fmt.Errorf("Notification too short: %d items", len(items))
+	}
+
+	event := ContractEvent{
+		Type:    items[0].String(),
+		MatchID: items[1].String(),
+		BetID:   items[2].String(),
+	}
+	args := items[3:]
+
+	switch event.Type {
+	case BetPlacedEvent:
+		// account, outcome, side, odds, stake
+		if len(args) < 5 {
+			return event, fmt.Errorf("Invalid %s notification", event.Type)
+		}
+		event.Account = hex.EncodeToString(args[0].Bytes())
+		event.Outcome = args[1].Int()
+		event.Side = args[2].String()
+		event.Odds = args[3].Int()
+		event.Stake = args[4].Int()
+	case BetMatchedEvent:
+		// odds, stake
+		if len(args) < 2 {
+			return event, fmt.Errorf("Invalid %s notification", event.Type)
+		}
+		event.Odds = args[0].Int()
+		event.Stake = args[1].Int()
+	case BetSettledEvent:
+		// result
+		if len(args) < 1 {
+			return event, fmt.Errorf("Invalid %s notification", event.Type)
+		}
+		event.Result = args[0].String()
+	default:
+		return event, fmt.Errorf("Unknown notification: %s", event.Type)
+	}
+
+	return event, nil
+}
+
+// Bytes returns the raw value of a ByteArray item
+func (s StackItem) Bytes() []byte {
+	var value string
+	if json.Unmarshal(s.Value, &value) != nil {
+		return nil
+	}
+
+	if s.Type != "ByteArray" {
+		return []byte(value)
+	}
+
+	bytes, err := hex.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+
+	return bytes
+}
+
+func (s StackItem) String() string {
+	return string(s.Bytes())
+}
+
+// Int reads an Integer item or a little-endian signed ByteArray
+func (s StackItem) Int() int64 {
+	if s.Type != "ByteArray" {
+		var value string
+		if json.Unmarshal(s.Value, &value) != nil {
+			return 0
+		}
+
+		integer, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return 0
+		}
+		return integer.Int64()
+	}
+
+	bytes := s.Bytes()
+	if len(bytes) < 1 {
+		return 0
+	}
+
+	bigEndian := make([]byte, len(bytes))
+	for i, b := range bytes {
+		bigEndian[len(bytes)-1-i] = b
+	}
+
+	integer := new(big.Int).SetBytes(bigEndian)
+	if bytes[len(bytes)-1]&0x80 != 0 {
+		integer.Sub(integer, new(big.Int).Lsh(big.NewInt(1), uint(len(bytes)*8)))
+	}
+
+	return integer.Int64()
+}
+
+func normaliseScriptHash(scriptHash string) string {
+	return strings.TrimPrefix(strings.ToLower(scriptHash), "0x")
+}
+
+func ContractEventsKey(matchID string) string {
+	return "contract-events-" + matchID
+}
+
+// ContractScanHeightKey holds the next block to scan, every block below it has had its events stored
+const ContractScanHeightKey = "contract-scan-height"
+
+// ScanContractBlocks scans blocks since the last run for betting contract events, storing and pushing each one
+func (svc *Service) ScanContractBlocks() {
+	if BettingContractHash == "" {
+		return
+	}
+
+	contractMutex.Lock()
+	defer contractMutex.Unlock()
+
+	height := svc.CurrentBlockHeight()
+
+	next, err := svc.RedisClient.Get(ContractScanHeightKey).Int64()
+	if err == redis.Nil {
+		// Start from the current block rather than the genesis block
+		next = height - 1
+	} else if err != nil {
+		svc.Logger.Log("error", err.Error())
+		return
+	}
+
+	rpc, err := svc.RPC()
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		return
+	}
+
+	watcher := NewContractWatcher(rpc, BettingContractHash)
+
+	// Block indexes run from 0 to height - 1
+	last := height - 1
+	if last-next >= ContractScanBatch {
+		last = next + ContractScanBatch - 1
+	}
+
+	for index := next; index >= 0 && index <= last; index++ {
+		events, err := watcher.ScanBlock(index)
+		if err != nil {
+			svc.Logger.Log("error", fmt.Sprintf("Unable to scan block %d: %v", index, err))
+			return
+		}
+
+		// A block is only marked scanned once all of its events are stored, so a failure part way
+		// through is rescanned in full rather than storing or pushing some events twice
+		err = svc.StoreContractEvents(index, events)
+		if err != nil {
+			svc.Logger.Log("error", fmt.Sprintf("Unable to store block %d: %v", index, err))
+			return
+		}
+
+		for _, event := range events {
+			err = svc.PublishContractEvent(event)
+			if err != nil {
+				svc.Logger.Log("error", err.Error())
+			}
+		}
+	}
+}
+
+// StoreContractEvents keeps a block's events against their matches and advances the scan height past it in one transaction
+func (svc *Service) StoreContractEvents(index int64, events []ContractEvent) error {
+	pipe := svc.RedisClient.TxPipeline()
+
+	for _, event := range events {
+		eventJSON, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		key := ContractEventsKey(event.MatchID)
+		pipe.RPush(key, eventJSON)
+		pipe.LTrim(key, -ContractEventHistory, -1)
+	}
+
+	pipe.Set(ContractScanHeightKey, index+1, 0)
+
+	_, err := pipe.Exec()
+	return err
+}
+
+// PublishContractEvent pushes a stored event to its match's channel
+func (svc *Service) PublishContractEvent(event ContractEvent) error {
+	encodedData, err := EncodeData(event)
+	if err != nil {
+		return err
+	}
+
+	return svc.PushWithRetry(PublishEvent{Channel: "market-" + event.MatchID, Event: "contract-event", Data: encodedData})
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeRPC answers calls from results keyed by method and first parameter
+type fakeRPC struct {
+	results map[string]interface{}
+}
+
+func (r *fakeRPC) Call(method string, params []interface{}, result interface{}) error {
+	key := method
+	if len(params) > 0 {
+		key = fmt.Sprintf("%s %v", method, params[0])
+	}
+
+	value, ok := r.results[key]
+	if !ok {
+		return fmt.Errorf("%s: no result", key)
+	}
+	if err, ok := value.(error); ok {
+		return err
+	}
+
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(valueJSON, result)
+}
+
+func byteArrayItem(value []byte) StackItem {
+	valueJSON, _ := json.Marshal(hex.EncodeToString(value))
+	return StackItem{Type: "ByteArray", Value: valueJSON}
+}
+
+func stringItem(value string) StackItem {
+	return byteArrayItem([]byte(value))
+}
+
+func integerItem(value string) StackItem {
+	valueJSON, _ := json.Marshal(value)
+	return StackItem{Type: "Integer", Value: valueJSON}
+}
+
+func testNotification(contract string, items ...StackItem) Notification {
+	itemsJSON, _ := json.Marshal(items)
+	return Notification{Contract: contract, State: StackItem{Type: "Array", Value: itemsJSON}}
+}
+
+func TestStackItem(t *testing.T) {
+	tests := []struct {
+		item   StackItem
+		str    string
+		number int64
+	}{
+		{stringItem("back"), "back", 0x6b636162},
+		{integerItem("250"), "250", 250},
+		{integerItem("-3"), "-3", -3},
+		{integerItem("not a number"), "not a number", 0},
+		// Little-endian with the sign in the top bit of the last byte
+		{byteArrayItem([]byte{0xfa, 0x00}), "\xfa\x00", 250},
+		{byteArrayItem([]byte{0xff}), "\xff", -1},
+		{byteArrayItem([]byte{0x00, 0x80}), "\x00\x80", -32768},
+		{byteArrayItem(nil), "", 0},
+		{StackItem{Type: "ByteArray", Value: json.RawMessage(`"zz"`)}, "", 0},
+	}
+
+	for _, test := range tests {
+		if value := test.item.String(); value != test.str {
+			t.Errorf("%s %s: String() = %q, expected %q", test.item.Type, test.item.Value, value, test.str)
+		}
+		if value := test.item.Int(); value != test.number {
+			t.Errorf("%s %s: Int() = %d, expected %d", test.item.Type, test.item.Value, value, test.number)
+		}
+	}
+}
+
+func TestDecodeNotification(t *testing.T) {
+	account := []byte{0x01, 0x02, 0x03}
+
+	tests := []struct {
+		name         string
+		notification Notification
+		event        ContractEvent
+		err          bool
+	}{
+		{
+			name: "placed",
+			notification: testNotification("", stringItem(BetPlacedEvent), stringItem("match"), stringItem("bet"),
+				byteArrayItem(account), integerItem("1"), stringItem("lay"), integerItem("250"), integerItem("1000")),
+			event: ContractEvent{Type: BetPlacedEvent, MatchID: "match", BetID: "bet", Account: "010203", Outcome: 1, Side: "lay", Odds: 250, Stake: 1000},
+		},
+		{
+			name:         "matched",
+			notification: testNotification("", stringItem(BetMatchedEvent), stringItem("match"), stringItem("bet"), integerItem("210"), integerItem("500")),
+			event:        ContractEvent{Type: BetMatchedEvent, MatchID: "match", BetID: "bet", Odds: 210, Stake: 500},
+		},
+		{
+			name:         "settled",
+			notification: testNotification("", stringItem(BetSettledEvent), stringItem("match"), stringItem("bet"), stringItem("won")),
+			event:        ContractEvent{Type: BetSettledEvent, MatchID: "match", BetID: "bet", Result: "won"},
+		},
+		{
+			name:         "missing arguments",
+			notification: testNotification("", stringItem(BetPlacedEvent), stringItem("match"), stringItem("bet"), integerItem("1")),
+			err:          true,
+		},
+		{
+			name:         "too short",
+			notification: testNotification("", stringItem(BetSettledEvent), stringItem("match")),
+			err:          true,
+		},
+		{
+			name:         "unknown",
+			notification: testNotification("", stringItem("transfer"), stringItem("match"), stringItem("bet")),
+			err:          true,
+		},
+		{
+			name:         "not an array",
+			notification: Notification{State: integerItem("1")},
+			err:          true,
+		},
+	}
+
+	for _, test := range tests {
+		event, err := DecodeNotification(test.notification)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if event != test.event {
+			t.Errorf("%s: decoded %+v, expected %+v", test.name, event, test.event)
+		}
+	}
+}
+
+func TestScanBlock(t *testing.T) {
+	placed := testNotification("0xABCDEF", stringItem(BetPlacedEvent), stringItem("match"), stringItem("bet"),
+		byteArrayItem([]byte{0x01}), integerItem("0"), stringItem("back"), integerItem("200"), integerItem("100"))
+	settled := testNotification("abcdef", stringItem(BetSettledEvent), stringItem("match"), stringItem("bet"), stringItem("lost"))
+	other := testNotification("123456", stringItem(BetSettledEvent), stringItem("match"), stringItem("other"), stringItem("won"))
+
+	rpc := &fakeRPC{results: map[string]interface{}{
+		"getblock 7": Block{Index: 7, Time: 1000, Transactions: []Transaction{
+			{TxID: "claim", Type: "ClaimTransaction"},
+			{TxID: "new", Type: "InvocationTransaction"},
+			{TxID: "old", Type: "InvocationTransaction"},
+			{TxID: "fault", Type: "InvocationTransaction"},
+		}},
+		"getapplicationlog new":   ApplicationLog{Executions: []Execution{{VMState: "HALT, BREAK", Notifications: []Notification{placed, other}}}},
+		"getapplicationlog old":   ApplicationLog{Notifications: []Notification{settled}},
+		"getapplicationlog fault": ApplicationLog{Executions: []Execution{{VMState: "FAULT, BREAK", Notifications: []Notification{settled}}}},
+	}}
+
+	events, err := NewContractWatcher(rpc, "0xabcdef").ScanBlock(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ContractEvent{
+		{Type: BetPlacedEvent, TxID: "new", BlockIndex: 7, BlockTime: 1000, MatchID: "match", BetID: "bet", Account: "01", Side: "back", Odds: 200, Stake: 100},
+		{Type: BetSettledEvent, TxID: "old", BlockIndex: 7, BlockTime: 1000, MatchID: "match", BetID: "bet", Result: "lost"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("%d events, expected %d: %+v", len(events), len(expected), events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("event %d = %+v, expected %+v", i, events[i], expected[i])
+		}
+	}
+
+	// A failed log lookup fails the whole block
+	rpc.results["getapplicationlog old"] = errors.New("node unavailable")
+	_, err = NewContractWatcher(rpc, "abcdef").ScanBlock(7)
+	if err == nil {
+		t.Error("expected the block to fail")
+	}
+}
+
+func TestScanContractBlocks(t *testing.T) {
+	defer func(hash string, retries int) {
+		BettingContractHash = hash
+		PushRetries = retries
+	}(BettingContractHash, PushRetries)
+	BettingContractHash = "abcdef"
+	PushRetries = 1
+
+	svc, server := newTestService(t)
+	defer server.Close()
+	publisher := &testPublisher{}
+	svc.Publishers = []*PublishBackend{NewPublishBackend("test", publisher)}
+
+	chain := NewMockChain(3, 15*time.Second)
+	svc.Chain = chain
+	chain.SetResult("getblock-1", Block{Index: 1, Transactions: []Transaction{{TxID: "tx", Type: "InvocationTransaction"}}})
+	chain.SetResult("getapplicationlog", ApplicationLog{Notifications: []Notification{
+		testNotification("abcdef", stringItem(BetSettledEvent), stringItem("match"), stringItem("bet"), stringItem("won")),
+	}})
+
+	err := svc.RedisClient.Set(ContractScanHeightKey, 1, 0).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Block 3 doesn't exist yet, so the scan stores block 1's event and stops after block 2
+	svc.Internals.BlockHeight = 4
+	publisher.SetError(errors.New("push failed"))
+	svc.ScanContractBlocks()
+	publisher.SetError(nil)
+
+	height, err := svc.RedisClient.Get(ContractScanHeightKey).Int64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 3 {
+		t.Errorf("scanned to %d, expected 3", height)
+	}
+
+	// A failed push isn't retried by rescanning the block
+	chain.Advance(1)
+	svc.ScanContractBlocks()
+
+	height, _ = svc.RedisClient.Get(ContractScanHeightKey).Int64()
+	if height != 4 {
+		t.Errorf("scanned to %d, expected 4", height)
+	}
+
+	stored, err := svc.RedisClient.LLen(ContractEventsKey("match")).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != 1 {
+		t.Errorf("%d events stored, expected 1", stored)
+	}
+	if events := publisher.Events(); len(events) != 0 {
+		t.Errorf("%d events pushed on rescan, expected none", len(events))
+	}
+
+	// The failed push is kept for replay instead
+	letters, _, err := svc.GetDeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Event != "contract-event" {
+		t.Errorf("dead letters = %+v, expected the contract event", letters)
+	}
+}
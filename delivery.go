@@ -0,0 +1,363 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Push retry settings
+var (
+	PushRetries   = 5
+	PushBaseDelay = 200 * time.Millisecond
+	PushMaxDelay  = 5 * time.Second
+)
+
+// Circuit breaker settings, pushes are dead-lettered straight away while the breaker is open
+var (
+	// Consecutive failures before the breaker opens
+	BreakerThreshold = 5
+	// How long the breaker stays open before a trial push is let through
+	BreakerCooldown = 30 * time.Second
+)
+
+// Number of dead letters kept
+var DeadLetterLimit = int64(1000)
+
+const DeadLetterKey = "push-dead-letters"
+
+var ErrCircuitOpen = fmt.Errorf("Push circuit open")
+
+// CircuitBreaker stops pushes to a backend after repeated failures so an outage isn't hammered with retries
+type CircuitBreaker struct {
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+// Allow reports whether a push can be attempted, once the cooldown has passed a single trial is let through
+func (b *CircuitBreaker) Allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.failures < BreakerThreshold {
+		return true
+	}
+
+	if time.Now().Before(b.openUntil) || b.trial {
+		return false
+	}
+
+	b.trial = true
+	return true
+}
+
+func (b *CircuitBreaker) Success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures = 0
+	b.trial = false
+}
+
+func (b *CircuitBreaker) Failure() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures++
+	b.trial = false
+	if b.failures >= BreakerThreshold {
+		b.openUntil = time.Now().Add(BreakerCooldown)
+	}
+}
+
+// Open reports whether pushes are currently being refused
+func (b *CircuitBreaker) Open() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.failures >= BreakerThreshold
+}
+
+type DeadLetter struct {
+	ID       string          `json:"id"`
+	Backend  string          `json:"backend"`
+	Channel  string          `json:"channel"`
+	Event    string          `json:"event"`
+	Sequence int64           `json:"sequence,omitempty"`
+	Data     json.RawMessage `json:"data"`
+	Error    string          `json:"error"`
+	Attempts int             `json:"attempts"`
+	FailedAt int64           `json:"failed_at"`
+}
+
+// PushWithRetry publishes an event to every backend at once, each retrying and dead-lettering on its own,
+// and returns the first backend's error
+func (svc *Service) PushWithRetry(event PublishEvent) error {
+	errs := make([]error, len(svc.Publishers))
+
+	var wg sync.WaitGroup
+	for i, backend := range svc.Publishers {
+		wg.Add(1)
+		go func(i int, backend *PublishBackend) {
+			defer wg.Done()
+			errs[i] = svc.pushToBackend(backend, event)
+		}(i, backend)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pushToBackend publishes an event to one backend, backing off exponentially with jitter between attempts,
+// and dead-letters it for that backend if every attempt fails or its breaker is open
+func (svc *Service) pushToBackend(backend *PublishBackend, event PublishEvent) (err error) {
+	attempts := 0
+
+	for attempts < PushRetries {
+		if !backend.Breaker.Allow() {
+			err = ErrCircuitOpen
+			break
+		}
+
+		attempts++
+		err = backend.Publisher.Publish(event.Channel, event.Event, event.Data)
+		if err == nil {
+			backend.Breaker.Success()
+			return
+		}
+		backend.Breaker.Failure()
+
+		if attempts < PushRetries {
+			time.Sleep(BackoffDelay(attempts))
+		}
+	}
+
+	svc.DeadLetter(backend.Name, event, err, attempts)
+	return
+}
+
+// BackoffDelay returns a random delay of up to PushBaseDelay doubled for each attempt, capped at PushMaxDelay
+func BackoffDelay(attempt int) time.Duration {
+	delay := PushBaseDelay << uint(attempt-1)
+	if delay > PushMaxDelay || delay <= 0 {
+		delay = PushMaxDelay
+	}
+
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// DeadLetter stores an event that couldn't be delivered to a backend so it can be inspected and replayed
+func (svc *Service) DeadLetter(backend string, event PublishEvent, pushErr error, attempts int) {
+	id, err := generateRandomID()
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		return
+	}
+
+	dataJSON, err := json.Marshal(event.Data)
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		return
+	}
+
+	letter := DeadLetter{
+		ID:       id,
+		Backend:  backend,
+		Channel:  event.Channel,
+		Event:    event.Event,
+		Sequence: event.Sequence,
+		Data:     dataJSON,
+		Attempts: attempts,
+		FailedAt: time.Now().Unix(),
+	}
+	if pushErr != nil {
+		letter.Error = pushErr.Error()
+	}
+
+	letterJSON, err := json.Marshal(letter)
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		return
+	}
+
+	err = svc.RedisClient.LPush(DeadLetterKey, letterJSON).Err()
+	if err == nil {
+		err = svc.RedisClient.LTrim(DeadLetterKey, 0, DeadLetterLimit-1).Err()
+	}
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+	}
+}
+
+// GetDeadLetters returns the stored dead letters, newest first, along with their raw values
+func (svc *Service) GetDeadLetters() ([]DeadLetter, []string, error) {
+	values, err := svc.RedisClient.LRange(DeadLetterKey, 0, -1).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	letters := []DeadLetter{}
+	var raw []string
+	for _, value := range values {
+		var letter DeadLetter
+		if json.Unmarshal([]byte(value), &letter) != nil {
+			continue
+		}
+
+		letters = append(letters, letter)
+		raw = append(raw, value)
+	}
+
+	return letters, raw, nil
+}
+
+// ChannelSequence returns the sequence of the latest message sent on a channel
+func (svc *Service) ChannelSequence(channelName string) (int64, error) {
+	var snapshot ChannelSnapshot
+	err := svc.GetRedis(ChannelSnapshotKey(channelName), &snapshot)
+	return snapshot.Sequence, err
+}
+
+// IsStaleLetter reports whether a dead letter has been overtaken by a later message on its channel,
+// app and market updates dead-lettered without a sequence can't be checked so they count as stale
+func (svc *Service) IsStaleLetter(letter DeadLetter) (bool, error) {
+	event := strings.TrimSuffix(letter.Event, "-chunk")
+	if letter.Sequence == 0 {
+		return event == AppUpdateEvent || event == AppDeltaEvent || event == MarketUpdateEvent, nil
+	}
+
+	// Market sequences are only held in memory
+	if event == MarketUpdateEvent {
+		return letter.Sequence != svc.Markets.Sequence(strings.TrimPrefix(letter.Channel, "market-")), nil
+	}
+
+	current, err := svc.ChannelSequence(letter.Channel)
+	if err == redis.Nil {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return letter.Sequence != current, nil
+}
+
+type ReplayResult struct {
+	Replayed int      `json:"replayed"`
+	Dropped  []string `json:"dropped"`
+	Failed   []string `json:"failed"`
+}
+
+// ReplayDeadLetters republishes dead letters to the backend that missed them, all of them when id is empty,
+// removing each one that's delivered and dropping any that a later message has made stale
+func (svc *Service) ReplayDeadLetters(id string) (ReplayResult, error) {
+	result := ReplayResult{
+		Dropped: []string{},
+		Failed:  []string{},
+	}
+
+	letters, raw, err := svc.GetDeadLetters()
+	if err != nil {
+		return result, err
+	}
+
+	// Replay oldest first so channels get events in their original order
+	for i := len(letters) - 1; i >= 0; i-- {
+		letter := letters[i]
+		if id != "" && letter.ID != id {
+			continue
+		}
+
+		stale, err := svc.IsStaleLetter(letter)
+		if err != nil {
+			return result, err
+		}
+
+		if stale {
+			err = svc.RedisClient.LRem(DeadLetterKey, 1, raw[i]).Err()
+			if err != nil {
+				return result, err
+			}
+			result.Dropped = append(result.Dropped, letter.ID)
+			continue
+		}
+
+		err = svc.replayLetter(letter)
+		if err != nil {
+			result.Failed = append(result.Failed, letter.ID)
+			continue
+		}
+
+		err = svc.RedisClient.LRem(DeadLetterKey, 1, raw[i]).Err()
+		if err != nil {
+			return result, err
+		}
+		result.Replayed++
+	}
+
+	return result, nil
+}
+
+// replayLetter publishes a dead letter once to its backend, or to every backend for letters stored without one
+func (svc *Service) replayLetter(letter DeadLetter) error {
+	var data interface{}
+	err := json.Unmarshal(letter.Data, &data)
+	if err != nil {
+		return err
+	}
+
+	err = fmt.Errorf("Unknown publisher: %s", letter.Backend)
+	for _, backend := range svc.Publishers {
+		if letter.Backend != "" && backend.Name != letter.Backend {
+			continue
+		}
+
+		if !backend.Breaker.Allow() {
+			return ErrCircuitOpen
+		}
+
+		err = backend.Publisher.Publish(letter.Channel, letter.Event, data)
+		if err != nil {
+			backend.Breaker.Failure()
+			return err
+		}
+		backend.Breaker.Success()
+	}
+
+	return err
+}
+
+func (svc *Service) DeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	letters, _, err := svc.GetDeadLetters()
+	if err != nil {
+		http.Error(w, "Unable to fetch dead letters", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(letters)
+}
+
+// ReplayDeadLettersHandler replays every dead letter, or only the one given by the id query param
+func (svc *Service) ReplayDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := svc.ReplayDeadLetters(r.URL.Query().Get("id"))
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		http.Error(w, "Unable to replay dead letters", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	defer func(threshold int, cooldown time.Duration) {
+		BreakerThreshold = threshold
+		BreakerCooldown = cooldown
+	}(BreakerThreshold, BreakerCooldown)
+	BreakerThreshold = 2
+	BreakerCooldown = time.Hour
+
+	breaker := &CircuitBreaker{}
+
+	tests := []struct {
+		step    string
+		action  func()
+		allowed bool
+		open    bool
+	}{
+		{"closed", func() {}, true, false},
+		{"one failure", breaker.Failure, true, false},
+		{"threshold", breaker.Failure, false, true},
+		{"cooldown over", func() { breaker.openUntil = time.Now() }, true, true},
+		// Only one trial is let through at a time
+		{"trial running", func() {}, false, true},
+		{"trial failed", breaker.Failure, false, true},
+		{"second cooldown", func() { breaker.openUntil = time.Now() }, true, true},
+		{"trial succeeded", breaker.Success, true, false},
+	}
+
+	for _, test := range tests {
+		test.action()
+
+		if allowed := breaker.Allow(); allowed != test.allowed {
+			t.Errorf("%s: allowed = %t, expected %t", test.step, allowed, test.allowed)
+		}
+		if open := breaker.Open(); open != test.open {
+			t.Errorf("%s: open = %t, expected %t", test.step, open, test.open)
+		}
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	defer func(base, max time.Duration) {
+		PushBaseDelay = base
+		PushMaxDelay = max
+	}(PushBaseDelay, PushMaxDelay)
+	PushBaseDelay = 200 * time.Millisecond
+	PushMaxDelay = 5 * time.Second
+
+	tests := []struct {
+		attempt int
+		limit   time.Duration
+	}{
+		{1, 200 * time.Millisecond},
+		{2, 400 * time.Millisecond},
+		{4, 1600 * time.Millisecond},
+		{6, 5 * time.Second},
+		// Shifting this far overflows, which is capped as well
+		{100, 5 * time.Second},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			delay := BackoffDelay(test.attempt)
+			if delay <= 0 || delay > test.limit {
+				t.Fatalf("attempt %d: delay %s outside (0, %s]", test.attempt, delay, test.limit)
+			}
+		}
+	}
+}
+
+// setUpBackends gives the service a failing pusher and a working hub
+func setUpBackends(svc *Service) (*testPublisher, *testPublisher) {
+	pusherPublisher := &testPublisher{err: errors.New("pusher down")}
+	hubPublisher := &testPublisher{}
+	svc.Publishers = []*PublishBackend{
+		NewPublishBackend("pusher", pusherPublisher),
+		NewPublishBackend("hub", hubPublisher),
+	}
+
+	return pusherPublisher, hubPublisher
+}
+
+func TestPublishEventsPerBackend(t *testing.T) {
+	defer func(retries int, delay time.Duration, threshold int) {
+		PushRetries = retries
+		PushBaseDelay = delay
+		BreakerThreshold = threshold
+	}(PushRetries, PushBaseDelay, BreakerThreshold)
+	PushRetries = 2
+	PushBaseDelay = time.Millisecond
+	BreakerThreshold = 3
+
+	svc, server := newTestService(t)
+	defer server.Close()
+	_, hubPublisher := setUpBackends(svc)
+
+	events := []PublishEvent{
+		{Channel: "soccer", Event: AppDeltaEvent, Data: "a", Sequence: 1},
+		{Channel: "tennis", Event: AppDeltaEvent, Data: "b", Sequence: 1},
+	}
+
+	for round := 1; round <= 2; round++ {
+		failures := svc.PublishEvents(events)
+
+		// The hub gets every event once a round even though pusher keeps failing and its breaker opens
+		if delivered := hubPublisher.Events(); len(delivered) != len(events)*round {
+			t.Errorf("round %d: hub has %d events, expected %d", round, len(delivered), len(events)*round)
+		}
+		if len(failures) != len(events) {
+			t.Errorf("round %d: %d failures, expected %d", round, len(failures), len(events))
+		}
+		for _, failure := range failures {
+			if failure.Backend != "pusher" {
+				t.Errorf("round %d: %s failed on %s", round, failure.Channel, failure.Backend)
+			}
+		}
+	}
+
+	if !svc.Publishers[0].Breaker.Open() || svc.Publishers[1].Breaker.Open() {
+		t.Error("only the pusher breaker should be open")
+	}
+
+	letters, _, err := svc.GetDeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != len(events)*2 {
+		t.Fatalf("%d dead letters, expected %d", len(letters), len(events)*2)
+	}
+	for _, letter := range letters {
+		if letter.Backend != "pusher" || letter.Sequence != 1 {
+			t.Errorf("dead letter for %s at %d, expected pusher at 1", letter.Backend, letter.Sequence)
+		}
+	}
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+	pusherPublisher, hubPublisher := setUpBackends(svc)
+	pusherPublisher.SetError(nil)
+
+	err := svc.SetRedis(ChannelSnapshotKey("soccer"), &ChannelSnapshot{Channel: "soccer", Sequence: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		backend  string
+		event    PublishEvent
+		replayed bool
+	}{
+		// Overtaken by sequence 5
+		{"pusher", PublishEvent{Channel: "soccer", Event: AppDeltaEvent, Data: "4", Sequence: 4}, false},
+		{"pusher", PublishEvent{Channel: "soccer", Event: AppDeltaEvent + "-chunk", Data: "5", Sequence: 5}, true},
+		// No snapshot to compare against
+		{"pusher", PublishEvent{Channel: "tennis", Event: AppUpdateEvent, Data: "1", Sequence: 1}, false},
+		// App updates without a sequence can't be checked
+		{"pusher", PublishEvent{Channel: "soccer", Event: AppUpdateEvent, Data: "old"}, false},
+		{"hub", PublishEvent{Channel: "market-1", Event: "contract-event", Data: "bet"}, true},
+	}
+
+	replayed := 0
+	for _, test := range tests {
+		svc.DeadLetter(test.backend, test.event, errors.New("failed"), 1)
+		if test.replayed {
+			replayed++
+		}
+	}
+
+	result, err := svc.ReplayDeadLetters("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Replayed != replayed || len(result.Dropped) != len(tests)-replayed || len(result.Failed) != 0 {
+		t.Errorf("replayed %d, dropped %d, failed %d, expected %d, %d and 0", result.Replayed, len(result.Dropped), len(result.Failed), replayed, len(tests)-replayed)
+	}
+
+	// Each letter only goes to the backend that missed it
+	pushed := pusherPublisher.Events()
+	if len(pushed) != 1 || pushed[0].Data != "5" {
+		t.Errorf("pusher got %+v, expected only the current delta", pushed)
+	}
+	pushed = hubPublisher.Events()
+	if len(pushed) != 1 || pushed[0].Data != "bet" {
+		t.Errorf("hub got %+v, expected only the contract event", pushed)
+	}
+
+	letters, _, err := svc.GetDeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 0 {
+		t.Errorf("%d dead letters left, expected none", len(letters))
+	}
+}
+
+func TestDeadLetterRoutesAreAdminOnly(t *testing.T) {
+	defer func(token string) { AdminToken = token }(AdminToken)
+	AdminToken = "secret"
+
+	svc, server := newTestService(t)
+	defer server.Close()
+	handler := svc.MakeHTTPHandler(context.Background(), log.NewNopLogger())
+
+	tests := []struct {
+		method string
+		path   string
+		token  string
+		code   int
+	}{
+		{"GET", "/admin/dead-letters", "", http.StatusUnauthorized},
+		{"GET", "/admin/dead-letters", "secret", http.StatusOK},
+		{"POST", "/admin/dead-letters/replay", "wrong", http.StatusUnauthorized},
+		{"POST", "/admin/dead-letters/replay", "secret", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.path, nil)
+		if test.token != "" {
+			request.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		if recorder.Code != test.code {
+			t.Errorf("%s %s with %q: %d, expected %d", test.method, test.path, test.token, recorder.Code, test.code)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+)
+
+// Interval between full app-update snapshots on a channel, deltas are sent in between
+var FullSnapshotInterval = time.Minute
+
+// Longest a channel goes without a push when its content hasn't changed
+var PushKeepalive = envDuration("PUSH_KEEPALIVE", 5*time.Minute)
+
+const (
+	AppUpdateEvent = "app-update"
+	AppDeltaEvent  = "app-delta"
+)
+
+// AppDeltaMessage lists the changes to a channel's matches since BaseSequence,
+// clients that don't hold BaseSequence should resync from the channel snapshot
+type AppDeltaMessage struct {
+	Sequence     int64    `json:"sequence"`
+	BaseSequence int64    `json:"base_sequence"`
+	Added        []Match  `json:"added"`
+	Changed      []Match  `json:"changed"`
+	Removed      []string `json:"removed"`
+	// Match IDs in display order
+	Order          []string            `json:"order"`
+	Currencies     map[string]Currency `json:"currencies,omitempty"`
+	BlockchainData BlockInfoResponse   `json:"blockchain_data"`
+}
+
+// ChannelSnapshot is the latest full state of a channel for clients resyncing
+type ChannelSnapshot struct {
+	Channel  string `json:"channel"`
+	Event    string `json:"event"`
+	Sequence int64  `json:"sequence"`
+	Data     string `json:"data"`
+}
+
+// DeltaStore keeps the last state sent on each channel
+type DeltaStore struct {
+	mutex    sync.Mutex
+	channels map[string]*channelState
+}
+
+type channelState struct {
+	mutex      sync.Mutex
+	sequence   int64
+	matches    map[string][]byte
+	currencies []byte
+	snapshotAt time.Time
+	// Hash of the content last delivered, ignoring blockchain data
+	contentHash string
+	pushedAt    time.Time
+	// Hashes of pushes still being delivered, by sequence
+	pending map[int64]string
+}
+
+func NewDeltaStore() *DeltaStore {
+	return &DeltaStore{
+		channels: make(map[string]*channelState),
+	}
+}
+
+func (d *DeltaStore) channel(name string) *channelState {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	state, ok := d.channels[name]
+	if !ok {
+		state = &channelState{
+			matches: make(map[string][]byte),
+			pending: make(map[int64]string),
+		}
+		d.channels[name] = state
+	}
+
+	return state
+}
+
+// Resolve records which pushes reached every backend, delivered content isn't pushed again until it
+// changes or the keepalive is due, while content that failed is pushed again on the next tick
+func (d *DeltaStore) Resolve(events []PublishEvent, failures []PublishFailure) {
+	failed := make(map[string]bool)
+	for _, failure := range failures {
+		failed[failure.Channel] = true
+	}
+
+	for _, event := range events {
+		d.mutex.Lock()
+		state, ok := d.channels[event.Channel]
+		d.mutex.Unlock()
+		if !ok || event.Sequence == 0 {
+			continue
+		}
+
+		state.mutex.Lock()
+		hash, pending := state.pending[event.Sequence]
+		if pending && !failed[event.Channel] {
+			state.contentHash = hash
+			state.pushedAt = time.Now()
+		}
+		delete(state.pending, event.Sequence)
+		state.mutex.Unlock()
+	}
+}
+
+func (s *channelState) isPending(hash string) bool {
+	for _, pendingHash := range s.pending {
+		if pendingHash == hash {
+			return true
+		}
+	}
+
+	return false
+}
+
+// next moves the channel on a sequence and works out the delta from the last message,
+// full is true when a snapshot is due instead
+func (s *channelState) next(message AppUpdateMessage) (delta AppDeltaMessage, full bool) {
+	s.sequence++
+
+	delta = AppDeltaMessage{
+		Sequence:       s.sequence,
+		BaseSequence:   s.sequence - 1,
+		Added:          []Match{},
+		Changed:        []Match{},
+		Removed:        []string{},
+		Order:          []string{},
+		BlockchainData: message.BlockchainData,
+	}
+
+	matches := make(map[string][]byte)
+	for _, match := range message.Matches {
+		id := match.ID()
+		matchJSON, _ := json.Marshal(match)
+		matches[id] = matchJSON
+		delta.Order = append(delta.Order, id)
+
+		previous, ok := s.matches[id]
+		switch {
+		case !ok:
+			delta.Added = append(delta.Added, match)
+		case !bytes.Equal(previous, matchJSON):
+			delta.Changed = append(delta.Changed, match)
+		}
+	}
+
+	for id := range s.matches {
+		if _, ok := matches[id]; !ok {
+			delta.Removed = append(delta.Removed, id)
+		}
+	}
+
+	currencies, _ := json.Marshal(message.Currencies)
+	if !bytes.Equal(currencies, s.currencies) {
+		delta.Currencies = message.Currencies
+	}
+
+	s.matches = matches
+	s.currencies = currencies
+
+	full = time.Since(s.snapshotAt) >= FullSnapshotInterval
+	if full {
+		s.snapshotAt = time.Now()
+	}
+
+	return
+}
+
+// ContentHash hashes an app update's currencies and matches, leaving out the sequence and blockchain data
+func ContentHash(message AppUpdateMessage) (string, error) {
+	message.Sequence = 0
+	message.BlockchainData = BlockInfoResponse{}
+
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(messageJSON)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+func ChannelSnapshotKey(channelName string) string {
+	return "channel-snapshot-" + channelName
+}
+
+// AppUpdateEvents encodes a channel's matches as a delta against the last message, or as a full
+// app-update when a snapshot is due, and stores the full state for clients that need to resync
+func (svc *Service) AppUpdateEvents(channelName string, message AppUpdateMessage) ([]PublishEvent, error) {
+	state := svc.Deltas.channel(channelName)
+
+	// Hold the channel so sequences are assigned in order
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	// Block height changes go out on the block channel, so skip channels whose content hasn't moved
+	hash, err := ContentHash(message)
+	if err != nil {
+		return nil, err
+	}
+	if (hash == state.contentHash && time.Since(state.pushedAt) < PushKeepalive) || state.isPending(hash) {
+		return nil, nil
+	}
+
+	delta, full := state.next(message)
+	message.Sequence = delta.Sequence
+
+	encodedData, err := EncodeData(message)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := ChannelSnapshot{
+		Channel:  channelName,
+		Event:    AppUpdateEvent,
+		Sequence: message.Sequence,
+		Data:     encodedData,
+	}
+
+	err = svc.SetRedis(ChannelSnapshotKey(channelName), &snapshot)
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+	}
+
+	if svc.Hub != nil {
+		svc.Hub.SetSnapshot(channelName, AppUpdateEvent, encodedData)
+	}
+
+	var events []PublishEvent
+	if full {
+		events, err = svc.EncodeEvents(message, channelName, AppUpdateEvent)
+	} else {
+		events, err = svc.EncodeEvents(delta, channelName, AppDeltaEvent)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The hash is only kept once Resolve hears the push was delivered
+	state.pending[message.Sequence] = hash
+
+	// Dead letters keep the sequence so a replay can tell when they've been overtaken
+	for i := range events {
+		events[i].Sequence = message.Sequence
+	}
+
+	return events, nil
+}
+
+func (svc *Service) ChannelSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	var snapshot ChannelSnapshot
+	err := svc.GetRedis(ChannelSnapshotKey(mux.Vars(r)["channel"]), &snapshot)
+	if err == redis.Nil {
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Unable to fetch snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(snapshot)
+}
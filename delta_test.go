@@ -0,0 +1,195 @@
+package service
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func matchIDs(matches []Match) []string {
+	ids := []string{}
+	for _, match := range matches {
+		ids = append(ids, match.ID())
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func sortedCopy(values []string) []string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func TestChannelStateNext(t *testing.T) {
+	defer func(interval time.Duration) { FullSnapshotInterval = interval }(FullSnapshotInterval)
+	FullSnapshotInterval = time.Hour
+
+	home := Match{Name: "Home v Away", StartDate: "1528988400", Matched: 10}
+	homeMoved := Match{Name: "Home v Away", StartDate: "1528988400", Matched: 20}
+	other := Match{Name: "Other v Side", StartDate: "1528988400"}
+	gas := map[string]Currency{"GAS": {"USD": 10}}
+	gasMoved := map[string]Currency{"GAS": {"USD": 11}}
+
+	tests := []struct {
+		name       string
+		matches    []Match
+		currencies map[string]Currency
+		added      []string
+		changed    []string
+		removed    []string
+		order      []string
+		currency   bool
+		full       bool
+	}{
+		{
+			name:       "first message",
+			matches:    []Match{home, other},
+			currencies: gas,
+			added:      []string{home.ID(), other.ID()},
+			changed:    []string{},
+			removed:    []string{},
+			order:      []string{home.ID(), other.ID()},
+			currency:   true,
+			full:       true,
+		},
+		{
+			name:       "nothing moved",
+			matches:    []Match{home, other},
+			currencies: gas,
+			added:      []string{},
+			changed:    []string{},
+			removed:    []string{},
+			order:      []string{home.ID(), other.ID()},
+		},
+		{
+			name:       "changed and reordered",
+			matches:    []Match{other, homeMoved},
+			currencies: gasMoved,
+			added:      []string{},
+			changed:    []string{home.ID()},
+			removed:    []string{},
+			order:      []string{other.ID(), home.ID()},
+			currency:   true,
+		},
+		{
+			name:       "removed",
+			matches:    []Match{homeMoved},
+			currencies: gasMoved,
+			added:      []string{},
+			changed:    []string{},
+			removed:    []string{other.ID()},
+			order:      []string{home.ID()},
+		},
+	}
+
+	state := NewDeltaStore().channel("soccer")
+
+	for i, test := range tests {
+		delta, full := state.next(AppUpdateMessage{Matches: test.matches, Currencies: test.currencies})
+
+		if delta.Sequence != int64(i+1) || delta.BaseSequence != int64(i) {
+			t.Errorf("%s: sequence %d from %d, expected %d from %d", test.name, delta.Sequence, delta.BaseSequence, i+1, i)
+		}
+		if full != test.full {
+			t.Errorf("%s: full = %t, expected %t", test.name, full, test.full)
+		}
+		if added := matchIDs(delta.Added); !reflect.DeepEqual(added, sortedCopy(test.added)) {
+			t.Errorf("%s: added %v, expected %v", test.name, added, test.added)
+		}
+		if changed := matchIDs(delta.Changed); !reflect.DeepEqual(changed, sortedCopy(test.changed)) {
+			t.Errorf("%s: changed %v, expected %v", test.name, changed, test.changed)
+		}
+		if !reflect.DeepEqual(sortedCopy(delta.Removed), sortedCopy(test.removed)) {
+			t.Errorf("%s: removed %v, expected %v", test.name, delta.Removed, test.removed)
+		}
+		if !reflect.DeepEqual(delta.Order, test.order) {
+			t.Errorf("%s: order %v, expected %v", test.name, delta.Order, test.order)
+		}
+		if (delta.Currencies != nil) != test.currency {
+			t.Errorf("%s: currencies sent = %t, expected %t", test.name, delta.Currencies != nil, test.currency)
+		}
+	}
+
+	// A snapshot is due again once the interval has passed
+	state.snapshotAt = time.Now().Add(-FullSnapshotInterval)
+	if _, full := state.next(AppUpdateMessage{Matches: []Match{homeMoved}}); !full {
+		t.Error("no snapshot after the interval")
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	base := AppUpdateMessage{
+		Sequence:   1,
+		Currencies: map[string]Currency{"GAS": {"USD": 10}},
+		Matches:    []Match{{Name: "Home v Away", StartDate: "1528988400"}},
+	}
+
+	tests := []struct {
+		name    string
+		message AppUpdateMessage
+		same    bool
+	}{
+		{"identical", base, true},
+		{"new sequence", AppUpdateMessage{Sequence: 2, Currencies: base.Currencies, Matches: base.Matches}, true},
+		{"new block", AppUpdateMessage{Sequence: 1, Currencies: base.Currencies, Matches: base.Matches, BlockchainData: BlockInfoResponse{BlockHeight: 100}}, true},
+		{"currencies moved", AppUpdateMessage{Sequence: 1, Currencies: map[string]Currency{"GAS": {"USD": 11}}, Matches: base.Matches}, false},
+		{"match changed", AppUpdateMessage{Sequence: 1, Currencies: base.Currencies, Matches: []Match{{Name: "Home v Away", StartDate: "1528988400", Matched: 5}}}, false},
+		{"no matches", AppUpdateMessage{Sequence: 1, Currencies: base.Currencies}, false},
+	}
+
+	baseHash, err := ContentHash(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		hash, err := ContentHash(test.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (hash == baseHash) != test.same {
+			t.Errorf("%s: same hash = %t, expected %t", test.name, hash == baseHash, test.same)
+		}
+	}
+}
+
+func TestAppUpdateEventsWaitForDelivery(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	message := AppUpdateMessage{Matches: []Match{{Name: "Home v Away", StartDate: "1528988400"}}}
+
+	tests := []struct {
+		name     string
+		failed   bool
+		expected bool
+	}{
+		{"first push", true, true},
+		// The failed push left nothing recorded, so the content goes again
+		{"after failure", false, true},
+		{"after delivery", false, false},
+	}
+
+	for _, test := range tests {
+		events, err := svc.AppUpdateEvents("soccer", message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (len(events) > 0) != test.expected {
+			t.Fatalf("%s: %d events", test.name, len(events))
+		}
+
+		// Content that's still being pushed isn't queued twice
+		if queued, _ := svc.AppUpdateEvents("soccer", message); len(queued) > 0 {
+			t.Errorf("%s: pushed again before delivery resolved", test.name)
+		}
+
+		var failures []PublishFailure
+		if test.failed {
+			failures = append(failures, PublishFailure{Backend: "pusher", Channel: "soccer", Event: AppUpdateEvent})
+		}
+		svc.Deltas.Resolve(events, failures)
+	}
+}
@@ -16,6 +16,30 @@ func (svc *Service) MakeHTTPHandler(ctx context.Context, logger log.Logger) http
 	r := mux.NewRouter()
 
 	r.HandleFunc("/health", svc.HealthCheckHandler).Methods("GET")
+	r.HandleFunc("/nodes", svc.NodePoolHandler).Methods("GET")
+	r.HandleFunc("/addresses/{address}", svc.AddressHandler).Methods("GET")
+	r.HandleFunc("/matches/{id}/history", svc.OddsHistoryHandler).Methods("GET")
+	r.HandleFunc("/matches/{id}/book", svc.OrderBookHandler).Methods("GET")
+	r.HandleFunc("/matches/{id}/orders", svc.PlaceOrderHandler).Methods("POST")
+	r.HandleFunc("/matches/{id}/cashout", svc.CashOutHandler).Methods("POST")
+	r.HandleFunc("/matches/{id}/settlement", svc.SettlementHandler).Methods("GET")
+	r.HandleFunc("/matches/{id}/settlement", AdminOnly(svc.SettleMatchHandler)).Methods("POST")
+	r.HandleFunc("/pusher/webhook", svc.PusherWebhookHandler).Methods("POST")
+	r.HandleFunc("/channels/{channel}/snapshot", svc.ChannelSnapshotHandler).Methods("GET")
+	r.HandleFunc("/reports/arbitrage", svc.ArbitrageReportHandler).Methods("GET")
+	r.HandleFunc("/reports/payloads", svc.PayloadSizesHandler).Methods("GET")
+	r.HandleFunc("/admin/dead-letters", AdminOnly(svc.DeadLettersHandler)).Methods("GET")
+	r.HandleFunc("/admin/dead-letters/replay", AdminOnly(svc.ReplayDeadLettersHandler)).Methods("POST")
+	r.HandleFunc("/accounts", svc.CreateAccountHandler).Methods("POST")
+	r.HandleFunc("/accounts/{id}", svc.AccountHandler).Methods("GET")
+	r.HandleFunc("/accounts/{id}/credit", svc.CreditAccountHandler).Methods("POST")
+	r.HandleFunc("/accounts/{id}/bets", svc.AccountBetsHandler).Methods("GET")
+	r.HandleFunc("/accounts/{id}/bets", svc.PlaceBetHandler).Methods("POST")
+
+	if svc.Hub != nil {
+		r.Handle("/ws", svc.Hub.WebSocketHandler()).Methods("GET")
+		r.HandleFunc("/events", svc.Hub.SSEHandler).Methods("GET")
+	}
 
 	isDev := os.Getenv("ENV") == "development"
 
@@ -36,7 +60,7 @@ func (svc *Service) MakeHTTPHandler(ctx context.Context, logger log.Logger) http
 	corsMiddleware := cors.New(cors.Options{
 		AllowedMethods: []string{"GET", "POST", "OPTIONS"},
 		AllowedOrigins: []string{"*"},
-		AllowedHeaders: []string{"Accept", "content-type", "Content-Length", "Accept-Encoding"},
+		AllowedHeaders: []string{"Accept", "content-type", "Content-Length", "Accept-Encoding", "Authorization"},
 	})
 	n.Use(corsMiddleware)
 
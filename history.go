@@ -0,0 +1,211 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+)
+
+// Maximum number of points kept in each match outcome's history
+var OddsHistoryRetention = int64(5000)
+
+// How long a match's history is kept after it starts, long enough to settle and calibrate against it
+var OddsHistoryExpiry = 30 * 24 * time.Hour
+
+type OddsPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Back      float64 `json:"back"`
+	Lay       float64 `json:"lay"`
+	Matched   float64 `json:"matched"`
+	Simulated float64 `json:"simulated"`
+
+	// Market conditions at the time of the point, used to calibrate pricing
+	Depth        int     `json:"depth"`
+	ExchangeRate float64 `json:"exchange_rate"`
+}
+
+// ID returns the seeded ID used to key a match across redis and push channels
+func (m Match) ID() string {
+	return GenerateSeededID(m.Name, m.StartDate)
+}
+
+func OddsHistoryKey(matchID string, outcome int) string {
+	return fmt.Sprintf("odds-history-%s-%d", matchID, outcome)
+}
+
+// HistoryExpiresAt returns when a match's history expires, counting from now if its start is unknown
+func HistoryExpiresAt(match Match) time.Time {
+	start, err := strconv.ParseInt(match.StartDate, 10, 64)
+	if err != nil {
+		return time.Now().Add(OddsHistoryExpiry)
+	}
+
+	return time.Unix(start, 0).Add(OddsHistoryExpiry)
+}
+
+// RecordOddsHistory appends a point for every outcome whose best back/lay, matched or simulated amount has changed
+func (svc *Service) RecordOddsHistory(previous, match Match) error {
+	if match.MatchOdds == nil {
+		return nil
+	}
+
+	now := time.Now()
+	matchID := match.ID()
+	bestOdds := FindBestOdds(match)
+
+	var previousOdds BestOdds
+	if previous.MatchOdds != nil {
+		previousOdds = FindBestOdds(previous)
+	}
+
+	for outcome := 0; outcome < match.Outcomes; outcome++ {
+		point := OddsPoint{
+			Timestamp: now.Unix(),
+			Back:      getOutcomeOdds(bestOdds.Back, outcome),
+			Lay:       getOutcomeOdds(bestOdds.Lay, outcome),
+			Matched:   match.Matched,
+			Simulated: match.SimulatedMatched,
+
+			Depth:        getOutcomeDepth(match.MatchOdds.Back, outcome),
+			ExchangeRate: svc.Internals.PriceDetails.ExchangeRate,
+		}
+
+		if point.Back == getOutcomeOdds(previousOdds.Back, outcome) &&
+			point.Lay == getOutcomeOdds(previousOdds.Lay, outcome) &&
+			point.Matched == previous.Matched &&
+			point.Simulated == previous.SimulatedMatched {
+			continue
+		}
+
+		pointJSON, err := json.Marshal(point)
+		if err != nil {
+			return err
+		}
+
+		key := OddsHistoryKey(matchID, outcome)
+		err = svc.RedisClient.ZAdd(key, redis.Z{
+			Score:  float64(now.UnixNano() / int64(time.Millisecond)),
+			Member: pointJSON,
+		}).Err()
+		if err != nil {
+			return err
+		}
+
+		err = svc.RedisClient.ZRemRangeByRank(key, 0, -OddsHistoryRetention-1).Err()
+		if err != nil {
+			return err
+		}
+
+		err = svc.RedisClient.ExpireAt(key, HistoryExpiresAt(match)).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetOddsHistory fetches an outcome's history between two times, keeping the last point in each resolution bucket
+func (svc *Service) GetOddsHistory(matchID string, outcome int, from, to time.Time, resolution time.Duration) ([]OddsPoint, error) {
+	rawPoints, err := svc.RedisClient.ZRangeByScore(OddsHistoryKey(matchID, outcome), redis.ZRangeBy{
+		Min: strconv.FormatInt(from.UnixNano()/int64(time.Millisecond), 10),
+		Max: strconv.FormatInt(to.UnixNano()/int64(time.Millisecond), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]OddsPoint, 0)
+	bucketSize := int64(resolution.Seconds())
+
+	for _, rawPoint := range rawPoints {
+		var point OddsPoint
+		err = json.Unmarshal([]byte(rawPoint), &point)
+		if err != nil {
+			return nil, err
+		}
+
+		if bucketSize > 0 && len(points) > 0 {
+			last := points[len(points)-1]
+			if last.Timestamp/bucketSize == point.Timestamp/bucketSize {
+				points[len(points)-1] = point
+				continue
+			}
+		}
+
+		points = append(points, point)
+	}
+
+	return points, nil
+}
+
+// OddsHistoryHandler serves an outcome's history, e.g. /matches/{id}/history?outcome=0&from=1530000000&to=1530086400&resolution=5m
+func (svc *Service) OddsHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	matchID := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	outcome, err := strconv.Atoi(query.Get("outcome"))
+	if err != nil {
+		http.Error(w, "Invalid outcome", http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now()
+	if query.Get("to") != "" {
+		toUnix, err := strconv.ParseInt(query.Get("to"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid to time", http.StatusBadRequest)
+			return
+		}
+		to = time.Unix(toUnix, 0)
+	}
+
+	from := to.Add(-24 * time.Hour)
+	if query.Get("from") != "" {
+		fromUnix, err := strconv.ParseInt(query.Get("from"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid from time", http.StatusBadRequest)
+			return
+		}
+		from = time.Unix(fromUnix, 0)
+	}
+
+	resolution := time.Duration(0)
+	if query.Get("resolution") != "" {
+		resolution, err = time.ParseDuration(query.Get("resolution"))
+		if err != nil {
+			http.Error(w, "Invalid resolution", http.StatusBadRequest)
+			return
+		}
+	}
+
+	points, err := svc.GetOddsHistory(matchID, outcome, from, to, resolution)
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		http.Error(w, "Unable to fetch odds history", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(points)
+}
+
+func getOutcomeOdds(odds []float64, outcome int) float64 {
+	if outcome < len(odds) {
+		return odds[outcome]
+	}
+
+	return 0
+}
+
+func getOutcomeDepth(ladders [][]Odds, outcome int) int {
+	if outcome < len(ladders) {
+		return len(ladders[outcome])
+	}
+
+	return 0
+}
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryExpiresAt(t *testing.T) {
+	start := time.Date(2018, 6, 14, 15, 0, 0, 0, time.UTC)
+
+	expiresAt := HistoryExpiresAt(Match{StartDate: "1528988400"})
+	if !expiresAt.Equal(start.Add(OddsHistoryExpiry)) {
+		t.Errorf("expires at %s, expected %s", expiresAt, start.Add(OddsHistoryExpiry))
+	}
+
+	// An unparseable start counts from now so the key still expires
+	expiresAt = HistoryExpiresAt(Match{StartDate: "soon"})
+	if expiresAt.Before(time.Now().Add(OddsHistoryExpiry - time.Minute)) {
+		t.Errorf("expires at %s, expected around %s", expiresAt, time.Now().Add(OddsHistoryExpiry))
+	}
+}
@@ -0,0 +1,365 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Hub client settings
+var (
+	// Number of messages buffered for each client, clients that fall this far behind are disconnected
+	HubClientBuffer = 64
+	// Interval between heartbeats so idle connections aren't closed by proxies
+	HubHeartbeatInterval = 25 * time.Second
+	// Time allowed for a single write before the client is treated as gone
+	HubWriteTimeout = 10 * time.Second
+)
+
+// Origins allowed to connect to the hub, from WS_ALLOWED_ORIGINS, any origin may connect when it is unset
+var HubAllowedOrigins = envList("WS_ALLOWED_ORIGINS")
+
+// Events kept per channel and sent to new subscribers as a snapshot
+var HubSnapshotEvents = map[string]bool{
+	"app-update":    true,
+	"market-update": true,
+}
+
+const (
+	HubSubscribedEvent = "subscription_succeeded"
+	HubHeartbeatEvent  = "heartbeat"
+)
+
+// Hub is a self hosted publisher, clients subscribe to channels over WebSocket or Server-Sent Events
+type Hub struct {
+	mutex   sync.RWMutex
+	clients map[*HubClient]bool
+	// Latest snapshot event published on each channel
+	snapshots map[string]map[string]HubMessage
+}
+
+// HubClient is a single WebSocket or SSE connection
+type HubClient struct {
+	Send     chan HubMessage
+	channels map[string]bool
+}
+
+// HubMessage mirrors a Pusher event, Data holds the same encoded payload
+type HubMessage struct {
+	Channel string      `json:"channel"`
+	Event   string      `json:"event"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// HubRequest is sent by WebSocket clients to change their subscriptions or ask for a channel snapshot
+type HubRequest struct {
+	Action  string `json:"action"`
+	Channel string `json:"channel"`
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		clients:   make(map[*HubClient]bool),
+		snapshots: make(map[string]map[string]HubMessage),
+	}
+}
+
+func (h *Hub) Register() *HubClient {
+	client := &HubClient{
+		Send:     make(chan HubMessage, HubClientBuffer),
+		channels: make(map[string]bool),
+	}
+
+	h.mutex.Lock()
+	h.clients[client] = true
+	h.mutex.Unlock()
+
+	return client
+}
+
+func (h *Hub) Unregister(client *HubClient) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.unregister(client)
+}
+
+func (h *Hub) unregister(client *HubClient) {
+	if h.clients[client] {
+		delete(h.clients, client)
+		close(client.Send)
+	}
+}
+
+// Subscribe adds a channel to a client, acknowledging it and queueing the channel's latest snapshot
+func (h *Hub) Subscribe(client *HubClient, channel string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.clients[client] {
+		return
+	}
+
+	client.channels[channel] = true
+
+	if !h.send(client, HubMessage{Channel: channel, Event: HubSubscribedEvent}) {
+		h.unregister(client)
+		return
+	}
+
+	h.sendSnapshot(client, channel)
+}
+
+// Resync queues the latest snapshot of a channel the client is subscribed to
+func (h *Hub) Resync(client *HubClient, channel string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.clients[client] && client.channels[channel] {
+		h.sendSnapshot(client, channel)
+	}
+}
+
+// SetSnapshot replaces the snapshot sent to new subscribers without publishing it
+func (h *Hub) SetSnapshot(channel, event string, data interface{}) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.setSnapshot(HubMessage{Channel: channel, Event: event, Data: data})
+}
+
+// RemoveSnapshots drops every snapshot held for a channel
+func (h *Hub) RemoveSnapshots(channel string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	delete(h.snapshots, channel)
+}
+
+func (h *Hub) setSnapshot(message HubMessage) {
+	if h.snapshots[message.Channel] == nil {
+		h.snapshots[message.Channel] = make(map[string]HubMessage)
+	}
+	h.snapshots[message.Channel][message.Event] = message
+}
+
+func (h *Hub) sendSnapshot(client *HubClient, channel string) {
+	for _, message := range h.snapshots[channel] {
+		if !h.send(client, message) {
+			h.unregister(client)
+			return
+		}
+	}
+}
+
+func (h *Hub) Unsubscribe(client *HubClient, channel string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	delete(client.channels, channel)
+}
+
+// Subscribers returns the number of clients subscribed to a channel
+func (h *Hub) Subscribers(channel string) (count int) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for client := range h.clients {
+		if client.channels[channel] {
+			count++
+		}
+	}
+
+	return
+}
+
+// Publish queues a message for every subscriber, disconnecting clients whose buffer is full
+// so a slow consumer can't hold up the others, they get a fresh snapshot when they resubscribe
+func (h *Hub) Publish(channel, event string, data interface{}) error {
+	message := HubMessage{
+		Channel: channel,
+		Event:   event,
+		Data:    data,
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if HubSnapshotEvents[event] {
+		h.setSnapshot(message)
+	}
+
+	for client := range h.clients {
+		if !client.channels[channel] {
+			continue
+		}
+
+		if !h.send(client, message) {
+			h.unregister(client)
+		}
+	}
+
+	return nil
+}
+
+// Heartbeat queues a heartbeat for a client without blocking, it's skipped if the client is already busy
+func (h *Hub) Heartbeat(client *HubClient) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.clients[client] {
+		h.send(client, HubMessage{Event: HubHeartbeatEvent})
+	}
+}
+
+func (h *Hub) send(client *HubClient, message HubMessage) bool {
+	select {
+	case client.Send <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *Hub) PublishBatch(events []PublishEvent) error {
+	for _, event := range events {
+		h.Publish(event.Channel, event.Event, event.Data)
+	}
+
+	return nil
+}
+
+// IsAllowedOrigin checks a client's origin against HubAllowedOrigins, clients that send no origin aren't browsers
+func IsAllowedOrigin(origin string) bool {
+	if len(HubAllowedOrigins) < 1 || origin == "" {
+		return true
+	}
+
+	for _, allowed := range HubAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WebSocketHandler serves hub clients over WebSocket, clients send subscribe and unsubscribe requests
+func (h *Hub) WebSocketHandler() http.Handler {
+	return websocket.Server{
+		// Browsers don't apply CORS to WebSocket connections, so the origin is checked here
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			origin := r.Header.Get("Origin")
+			if !IsAllowedOrigin(origin) {
+				return fmt.Errorf("Origin not allowed: %s", origin)
+			}
+
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			client := h.Register()
+			defer h.Unregister(client)
+
+			go func() {
+				heartbeat := time.NewTicker(HubHeartbeatInterval)
+				defer heartbeat.Stop()
+				defer conn.Close()
+
+				for {
+					select {
+					case <-heartbeat.C:
+						h.Heartbeat(client)
+					case message, ok := <-client.Send:
+						if !ok {
+							return
+						}
+
+						conn.SetWriteDeadline(time.Now().Add(HubWriteTimeout))
+						if websocket.JSON.Send(conn, message) != nil {
+							return
+						}
+					}
+				}
+			}()
+
+			for {
+				var request HubRequest
+				err := websocket.JSON.Receive(conn, &request)
+				if err != nil {
+					return
+				}
+
+				switch request.Action {
+				case "subscribe":
+					h.Subscribe(client, request.Channel)
+				case "unsubscribe":
+					h.Unsubscribe(client, request.Channel)
+				case "resync":
+					h.Resync(client, request.Channel)
+				}
+			}
+		},
+	}
+}
+
+// SSEHandler serves hub clients over Server-Sent Events, channels are given as channel query params
+func (h *Hub) SSEHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	if !IsAllowedOrigin(r.Header.Get("Origin")) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	channels := r.URL.Query()["channel"]
+	if len(channels) < 1 {
+		http.Error(w, "No channels given", http.StatusBadRequest)
+		return
+	}
+
+	client := h.Register()
+	defer h.Unregister(client)
+
+	for _, channel := range channels {
+		h.Subscribe(client, channel)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(HubHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			h.Heartbeat(client)
+		case message, ok := <-client.Send:
+			if !ok {
+				return
+			}
+
+			messageJSON, err := json.Marshal(message)
+			if err != nil {
+				continue
+			}
+
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", message.Event, messageJSON)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestIsAllowedOrigin(t *testing.T) {
+	defer func(origins []string) { HubAllowedOrigins = origins }(HubAllowedOrigins)
+
+	tests := []struct {
+		allowed  []string
+		origin   string
+		expected bool
+	}{
+		{nil, "https://anywhere.example", true},
+		{[]string{"https://app.example"}, "https://app.example", true},
+		{[]string{"https://app.example"}, "HTTPS://APP.EXAMPLE", true},
+		{[]string{"https://app.example"}, "https://evil.example", false},
+		{[]string{"https://app.example"}, "", true},
+		{[]string{"https://app.example", "*"}, "https://evil.example", true},
+	}
+
+	for _, test := range tests {
+		HubAllowedOrigins = test.allowed
+		if IsAllowedOrigin(test.origin) != test.expected {
+			t.Errorf("IsAllowedOrigin(%q) with %v = %t", test.origin, test.allowed, !test.expected)
+		}
+	}
+}
+
+func TestHubRejectsOrigins(t *testing.T) {
+	defer func(origins []string) { HubAllowedOrigins = origins }(HubAllowedOrigins)
+	HubAllowedOrigins = []string{"https://app.example"}
+
+	hub := NewHub()
+	server := httptest.NewServer(hub.WebSocketHandler())
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, err := websocket.Dial(url, "", "https://app.example")
+	if err != nil {
+		t.Fatalf("allowed origin refused: %v", err)
+	}
+	conn.Close()
+
+	_, err = websocket.Dial(url, "", "https://evil.example")
+	if err == nil {
+		t.Error("other origin connected")
+	}
+
+	request := httptest.NewRequest("GET", "/events?channel=block", nil)
+	request.Header.Set("Origin", "https://evil.example")
+	recorder := httptest.NewRecorder()
+	hub.SSEHandler(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("SSE from other origin got %d, expected %d", recorder.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"math/rand"
+
+	"github.com/a-h/round"
+	"github.com/go-redis/redis"
+)
+
+// Redis hash of cumulative matched volume (in GAS) keyed by match ID, only real fills against the book are recorded
+const MatchedLedgerKey = "matched-ledger"
+
+// Redis hash of volume (in GAS) generated by the market simulation keyed by match ID, kept apart from real fills
+const SimulatedLedgerKey = "simulated-ledger"
+
+// GetMatchedVolume returns the cumulative matched volume recorded for a match
+func (svc *Service) GetMatchedVolume(matchID string) (float64, error) {
+	return svc.getLedgerVolume(MatchedLedgerKey, matchID)
+}
+
+// AddMatchedVolume records a fill against a match and returns the new cumulative volume, the ledger never decreases
+func (svc *Service) AddMatchedVolume(matchID string, amount float64) (float64, error) {
+	return svc.addLedgerVolume(MatchedLedgerKey, matchID, amount)
+}
+
+// GetSimulatedVolume returns the simulated volume generated for a match
+func (svc *Service) GetSimulatedVolume(matchID string) (float64, error) {
+	return svc.getLedgerVolume(SimulatedLedgerKey, matchID)
+}
+
+// AddSimulatedVolume records a simulated fill against a match and returns its new simulated volume
+func (svc *Service) AddSimulatedVolume(matchID string, amount float64) (float64, error) {
+	return svc.addLedgerVolume(SimulatedLedgerKey, matchID, amount)
+}
+
+func (svc *Service) getLedgerVolume(ledgerKey, matchID string) (float64, error) {
+	volume, err := svc.RedisClient.HGet(ledgerKey, matchID).Float64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+
+	return volume, err
+}
+
+func (svc *Service) addLedgerVolume(ledgerKey, matchID string, amount float64) (float64, error) {
+	if amount <= 0 {
+		return svc.getLedgerVolume(ledgerKey, matchID)
+	}
+
+	return svc.RedisClient.HIncrByFloat(ledgerKey, matchID, amount).Result()
+}
+
+// PruneMatchedLedger drops the real and simulated volume of matches that are no longer listed once their odds
+// history has expired, so calibration and settlement can still read it until then
+func (svc *Service) PruneMatchedLedger(active map[string]Match) error {
+	// An empty listing is more likely a failed fetch than every match finishing
+	if len(active) < 1 {
+		return nil
+	}
+
+	for _, ledgerKey := range []string{MatchedLedgerKey, SimulatedLedgerKey} {
+		err := svc.pruneLedger(ledgerKey, active)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (svc *Service) pruneLedger(ledgerKey string, active map[string]Match) error {
+	matchIDs, err := svc.RedisClient.HKeys(ledgerKey).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, matchID := range matchIDs {
+		if _, ok := active[matchID]; ok {
+			continue
+		}
+
+		exists, err := svc.RedisClient.Exists(OddsHistoryKey(matchID, 0)).Result()
+		if err != nil {
+			return err
+		}
+
+		if exists > 0 {
+			continue
+		}
+
+		err = svc.RedisClient.HDel(ledgerKey, matchID).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SimulateFill generates a fill that moves the simulated volume part of the way towards its target curve
+func SimulateFill(current, target, timeScale float64) float64 {
+	gap := target - current
+	if gap <= 0 {
+		return 0
+	}
+
+	// Markets trade more actively closer to kickoff
+	fill := gap * rand.Float64() * (0.1 + 0.4*timeScale)
+
+	return round.AwayFromZero(fill, 1)
+}
+
+// RollUpMatched totals the volume traded on a list of matches, real and simulated,
+// so competition and sport totals move with the market before anyone bets
+func RollUpMatched(matches []Match) float64 {
+	total := float64(0)
+	for _, match := range matches {
+		total += match.Matched + match.SimulatedMatched
+	}
+
+	return total
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestPruneMatchedLedger(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	server.Do("hset", MatchedLedgerKey, "listed", "10", "recent", "20", "expired", "30")
+	server.Do("zadd", OddsHistoryKey("recent", 0), "1", "{}")
+
+	active := map[string]Match{"listed": {}}
+
+	err := svc.PruneMatchedLedger(active)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		matchID string
+		matched float64
+	}{
+		{"listed", 10},
+		// Still has history so calibration and settlement can read its volume
+		{"recent", 20},
+		{"expired", 0},
+	}
+
+	for _, test := range tests {
+		matched, err := svc.GetMatchedVolume(test.matchID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if matched != test.matched {
+			t.Errorf("%s matched = %f, expected %f", test.matchID, matched, test.matched)
+		}
+	}
+
+	// An empty listing leaves the ledger alone
+	err = svc.PruneMatchedLedger(map[string]Match{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if matched, _ := svc.GetMatchedVolume("recent"); matched != 20 {
+		t.Errorf("recent pruned by an empty listing")
+	}
+}
+
+func TestRollUpMatched(t *testing.T) {
+	tests := []struct {
+		matches []Match
+		total   float64
+	}{
+		{nil, 0},
+		// Simulated volume counts towards totals before anyone bets
+		{[]Match{{SimulatedMatched: 40}, {SimulatedMatched: 60}}, 100},
+		{[]Match{{Matched: 5, SimulatedMatched: 40}, {Matched: 10}}, 55},
+	}
+
+	for i, test := range tests {
+		if total := RollUpMatched(test.matches); total != test.total {
+			t.Errorf("case %d: total = %f, expected %f", i, total, test.total)
+		}
+	}
+}
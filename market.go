@@ -0,0 +1,348 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MarketUpdateEvent = "market-update"
+	MarketOpen        = "open"
+	MarketSuspended   = "suspended"
+)
+
+// Pusher channels with at least one subscriber, kept up to date by channel existence webhooks
+const WatchedMarketsKey = "watched-markets"
+
+// MarketStore keeps the sequence and last delivered update of each market channel
+type MarketStore struct {
+	mutex   sync.Mutex
+	markets map[string]*marketState
+	// Held while a push is built, so pushes are queued in the order their sequences were assigned
+	building sync.Mutex
+	queue    *PublishQueue
+}
+
+type marketState struct {
+	sequence int64
+	// Hash of the update last delivered
+	hash string
+	// Hashes of pushes still being delivered, by sequence
+	pending map[int64]string
+}
+
+func NewMarketStore() *MarketStore {
+	return &MarketStore{
+		markets: make(map[string]*marketState),
+		queue:   NewPublishQueue(),
+	}
+}
+
+func (m *MarketStore) market(matchID string) *marketState {
+	state, ok := m.markets[matchID]
+	if !ok {
+		state = &marketState{pending: make(map[int64]string)}
+		m.markets[matchID] = state
+	}
+
+	return state
+}
+
+// Sequence returns the sequence of the last update built for a match, zero if none has been
+func (m *MarketStore) Sequence(matchID string) int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if state, ok := m.markets[matchID]; ok {
+		return state.sequence
+	}
+
+	return 0
+}
+
+// Forget drops the delivered hash of a match so its next update is pushed even if unchanged
+func (m *MarketStore) Forget(matchID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if state, ok := m.markets[matchID]; ok {
+		state.hash = ""
+	}
+}
+
+// Resolve records which market updates reached every backend, an update that failed is pushed again next time
+func (m *MarketStore) Resolve(events []PublishEvent, failures []PublishFailure) {
+	failed := make(map[string]bool)
+	for _, failure := range failures {
+		failed[failure.Channel] = true
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, event := range events {
+		state, ok := m.markets[strings.TrimPrefix(event.Channel, "market-")]
+		if !ok || event.Sequence == 0 {
+			continue
+		}
+
+		hash, pending := state.pending[event.Sequence]
+		if pending && !failed[event.Channel] {
+			state.hash = hash
+		}
+		delete(state.pending, event.Sequence)
+	}
+}
+
+// Prune forgets matches that are no longer listed and returns their IDs
+func (m *MarketStore) Prune(active map[string]Match) []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var pruned []string
+	for matchID := range m.markets {
+		if _, ok := active[matchID]; !ok {
+			delete(m.markets, matchID)
+			pruned = append(pruned, matchID)
+		}
+	}
+
+	return pruned
+}
+
+func (s *marketState) isPending(hash string) bool {
+	for _, pendingHash := range s.pending {
+		if pendingHash == hash {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MarketUpdate is the detail of a single match pushed on its market channel
+type MarketUpdate struct {
+	MatchID         string  `json:"match_id"`
+	Name            string  `json:"name"`
+	Status          string  `json:"status"`
+	SuspendedReason string  `json:"suspended_reason,omitempty"`
+	Matched         float64 `json:"matched"`
+	// Volume generated by the market simulation, never included in Matched
+	SimulatedMatched float64   `json:"simulated_matched"`
+	MatchOdds        MatchOdds `json:"match_odds"`
+	UpdatedAt        int64     `json:"updated_at"`
+	// Increases with every update on the channel, clients drop any update older than the one they hold
+	Sequence int64 `json:"sequence"`
+}
+
+func MarketChannel(matchID string) string {
+	return "market-" + matchID
+}
+
+// PushMarketUpdates publishes the detail of every watched match whose book has changed since its last push
+func (svc *Service) PushMarketUpdates(matches []Match) {
+	watched, err := svc.RedisClient.SMembers(WatchedMarketsKey).Result()
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		return
+	}
+
+	pusherWatched := make(map[string]bool)
+	for _, channel := range watched {
+		pusherWatched[channel] = true
+	}
+
+	svc.Markets.building.Lock()
+	defer svc.Markets.building.Unlock()
+
+	var events []PublishEvent
+	for _, match := range matches {
+		channel := MarketChannel(match.ID())
+		if !pusherWatched[channel] && (svc.Hub == nil || svc.Hub.Subscribers(channel) < 1) {
+			continue
+		}
+
+		matchEvents, err := svc.MarketUpdateEvents(match)
+		if err != nil {
+			svc.Logger.Log("error", fmt.Sprintf("Error encoding data %s: %s", channel, err.Error()))
+			continue
+		}
+		events = append(events, matchEvents...)
+	}
+
+	if len(events) < 1 {
+		return
+	}
+
+	// A slow push can't be overtaken by a later update on the same market
+	svc.Markets.queue.Go(func() {
+		failures := svc.PublishEvents(events)
+		for _, failure := range failures {
+			svc.Logger.Log("error", fmt.Sprintf("Error pushing data %s to %s: %s", failure.Channel, failure.Backend, failure.Err.Error()))
+		}
+
+		svc.Markets.Resolve(events, failures)
+	})
+}
+
+// MarketUpdateEvents builds a match's market update from its live book, returning nothing if it hasn't
+// changed since it was last delivered or while the same update is still being pushed
+func (svc *Service) MarketUpdateEvents(match Match) ([]PublishEvent, error) {
+	matchID := match.ID()
+
+	matched, err := svc.GetMatchedVolume(matchID)
+	if err != nil {
+		return nil, err
+	}
+
+	simulated, err := svc.GetSimulatedVolume(matchID)
+	if err != nil {
+		return nil, err
+	}
+
+	update := MarketUpdate{
+		MatchID:          matchID,
+		Name:             match.Name,
+		Status:           MarketOpen,
+		Matched:          matched,
+		SimulatedMatched: simulated,
+		MatchOdds:        svc.OrderBooks.Ladder(matchID, match.Outcomes),
+	}
+
+	if match.Suspended {
+		update.Status = MarketSuspended
+		update.SuspendedReason = match.SuspendedReason
+	}
+
+	updateJSON, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(updateJSON)
+	hash := hex.EncodeToString(sum[:])
+
+	svc.Markets.mutex.Lock()
+	defer svc.Markets.mutex.Unlock()
+
+	state := svc.Markets.market(matchID)
+	if hash == state.hash || state.isPending(hash) {
+		return nil, nil
+	}
+
+	update.Sequence = state.sequence + 1
+	update.UpdatedAt = time.Now().Unix()
+	events, err := svc.EncodeEvents(update, MarketChannel(matchID), MarketUpdateEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	state.sequence = update.Sequence
+	state.pending[update.Sequence] = hash
+
+	for i := range events {
+		events[i].Sequence = update.Sequence
+	}
+
+	return events, nil
+}
+
+// PruneMarketHashes forgets matches that are no longer listed, along with their hub snapshots and payload sizes
+func (svc *Service) PruneMarketHashes(active map[string]Match) {
+	channels := make(map[string]bool)
+	for _, matchID := range svc.Markets.Prune(active) {
+		channels[MarketChannel(matchID)] = true
+	}
+
+	// Payload sizes outlive a restart, so look for their channels as well
+	payloadChannels, err := svc.RedisClient.SMembers(PayloadChannelsKey).Result()
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+	}
+	for _, channel := range payloadChannels {
+		if !strings.HasPrefix(channel, "market-") {
+			continue
+		}
+		if _, ok := active[strings.TrimPrefix(channel, "market-")]; !ok {
+			channels[channel] = true
+		}
+	}
+
+	if len(channels) < 1 {
+		return
+	}
+
+	pipe := svc.RedisClient.TxPipeline()
+	for channel := range channels {
+		if svc.Hub != nil {
+			svc.Hub.RemoveSnapshots(channel)
+		}
+
+		pipe.SRem(PayloadChannelsKey, channel)
+		pipe.Del(PayloadSizesKey(channel))
+	}
+
+	_, err = pipe.Exec()
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+	}
+}
+
+// PusherWebhookHandler tracks which market channels are occupied from Pusher's channel existence webhooks,
+// a newly watched market is pushed straight away
+func (svc *Service) PusherWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if svc.PusherClient == nil {
+		http.Error(w, "Pusher not configured", http.StatusNotFound)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid webhook", http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := svc.PusherClient.Webhook(r.Header, body)
+	if err != nil {
+		http.Error(w, "Invalid webhook", http.StatusUnauthorized)
+		return
+	}
+
+	for _, event := range webhook.Events {
+		if !strings.HasPrefix(event.Channel, "market-") {
+			continue
+		}
+
+		switch event.Name {
+		case "channel_occupied":
+			err = svc.RedisClient.SAdd(WatchedMarketsKey, event.Channel).Err()
+			if err != nil {
+				break
+			}
+
+			matchID := strings.TrimPrefix(event.Channel, "market-")
+
+			svc.Markets.Forget(matchID)
+
+			if match, err := svc.GetMatch(matchID); err == nil {
+				go svc.PushMarketUpdates([]Match{match})
+			}
+		case "channel_vacated":
+			err = svc.RedisClient.SRem(WatchedMarketsKey, event.Channel).Err()
+		}
+
+		if err != nil {
+			svc.Logger.Log("error", err.Error())
+			http.Error(w, "Unable to record webhook", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode("OK")
+}
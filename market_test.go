@@ -0,0 +1,158 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestMarketUpdateEventsWaitForDelivery(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	match := storeTestMatch(t, svc)
+
+	tests := []struct {
+		name     string
+		failed   bool
+		sequence int64
+	}{
+		{"first push", true, 1},
+		// The failed push left nothing recorded, so the update goes again on the next sequence
+		{"after failure", false, 2},
+		{"after delivery", false, 0},
+	}
+
+	for _, test := range tests {
+		events, err := svc.MarketUpdateEvents(match)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (len(events) > 0) != (test.sequence > 0) {
+			t.Fatalf("%s: %d events", test.name, len(events))
+		}
+
+		for _, event := range events {
+			var update MarketUpdate
+			decodeTestData(t, event.Data.(string), &update)
+			if event.Sequence != test.sequence || update.Sequence != test.sequence {
+				t.Errorf("%s: event at %d carrying %d, expected %d", test.name, event.Sequence, update.Sequence, test.sequence)
+			}
+		}
+
+		// An update that's still being pushed isn't queued twice
+		if queued, _ := svc.MarketUpdateEvents(match); len(queued) > 0 {
+			t.Errorf("%s: pushed again before delivery resolved", test.name)
+		}
+
+		var failures []PublishFailure
+		if test.failed {
+			failures = append(failures, PublishFailure{Backend: "pusher", Channel: MarketChannel(match.ID()), Event: MarketUpdateEvent})
+		}
+		svc.Markets.Resolve(events, failures)
+	}
+
+	// A newly watched channel gets the update again without moving the sequence back
+	svc.Markets.Forget(match.ID())
+	events, err := svc.MarketUpdateEvents(match)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) < 1 || events[0].Sequence != 3 {
+		t.Errorf("forgotten market pushed %+v, expected sequence 3", events)
+	}
+}
+
+func TestPruneMarketHashes(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+	svc.Hub = NewHub()
+
+	active := storeTestMatch(t, svc)
+	listed := map[string]Match{active.ID(): active}
+
+	// Only in redis, as if recorded before a restart
+	stored := MarketChannel("stored")
+	svc.RecordPayloadSize(stored, 10)
+
+	for _, matchID := range []string{active.ID(), "gone"} {
+		channel := MarketChannel(matchID)
+		svc.Markets.mutex.Lock()
+		svc.Markets.market(matchID).sequence = 1
+		svc.Markets.mutex.Unlock()
+		svc.Hub.SetSnapshot(channel, MarketUpdateEvent, "update")
+		svc.RecordPayloadSize(channel, 10)
+	}
+
+	svc.PruneMarketHashes(listed)
+
+	tests := []struct {
+		matchID string
+		pushed  bool
+		kept    bool
+	}{
+		{active.ID(), true, true},
+		{"gone", true, false},
+		{"stored", false, false},
+	}
+
+	channels, err := svc.RedisClient.SMembers(PayloadChannelsKey).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := make(map[string]bool)
+	for _, channel := range channels {
+		recorded[channel] = true
+	}
+
+	for _, test := range tests {
+		channel := MarketChannel(test.matchID)
+
+		if test.pushed && (svc.Markets.Sequence(test.matchID) > 0) != test.kept {
+			t.Errorf("%s: sequence kept = %t, expected %t", test.matchID, !test.kept, test.kept)
+		}
+		if _, ok := svc.Hub.snapshots[channel]; test.pushed && ok != test.kept {
+			t.Errorf("%s: hub snapshot kept = %t, expected %t", test.matchID, ok, test.kept)
+		}
+		if recorded[channel] != test.kept {
+			t.Errorf("%s: payload channel kept = %t, expected %t", test.matchID, recorded[channel], test.kept)
+		}
+
+		sizes, err := svc.RedisClient.HGetAll(PayloadSizesKey(channel)).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (len(sizes) > 0) != test.kept {
+			t.Errorf("%s: payload sizes kept = %t, expected %t", test.matchID, len(sizes) > 0, test.kept)
+		}
+	}
+}
+
+func TestStaleMarketLetters(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	svc.Markets.mutex.Lock()
+	svc.Markets.market("1").sequence = 3
+	svc.Markets.mutex.Unlock()
+
+	tests := []struct {
+		letter DeadLetter
+		stale  bool
+	}{
+		{DeadLetter{Channel: MarketChannel("1"), Event: MarketUpdateEvent, Sequence: 3}, false},
+		{DeadLetter{Channel: MarketChannel("1"), Event: MarketUpdateEvent + "-chunk", Sequence: 2}, true},
+		// No sequence to compare against
+		{DeadLetter{Channel: MarketChannel("1"), Event: MarketUpdateEvent}, true},
+		// Not pushed since a restart
+		{DeadLetter{Channel: MarketChannel("2"), Event: MarketUpdateEvent, Sequence: 1}, true},
+	}
+
+	for _, test := range tests {
+		stale, err := svc.IsStaleLetter(test.letter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stale != test.stale {
+			t.Errorf("%s %s at %d: stale = %t, expected %t", test.letter.Channel, test.letter.Event, test.letter.Sequence, stale, test.stale)
+		}
+	}
+}
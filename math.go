@@ -35,44 +35,27 @@ func GenerateSeededID(matchName, matchDate string) string {
 	return strings.Replace(encoded, "/", "a", -1)
 }
 
-// GetBestOdds gets the best odds as averaged from the aggregated sites
-func (svc *Service) GetBestOdds(match Match, allOdds []ThreeWayOdd) BestOdds {
+// GetBestOdds gets the best odds from the consensus of the aggregated sites and records which providers contributed
+func (svc *Service) GetBestOdds(match *Match, allOdds []ProviderOdd) BestOdds {
 	numOutcomes := match.Outcomes
 	scale := match.Scale
 
-	fnLayDifference := makeLogistical([4]float64{186.2695, 4.2213, 29.5378, -0.07})
-	numSites := []float64{0, 0, 0}
+	fnLayDifference := makeLogistical(Pricing.LayDifference)
 	backOdds := make([]float64, numOutcomes)
 	layOdds := make([]float64, numOutcomes)
 
-	for _, odds := range allOdds {
-		for i := 0; i < numOutcomes; i++ {
-			var oddsString string
-			switch i {
-			case 0:
-				oddsString = odds.HomeOdds
-			case 1:
-				oddsString = odds.AwayOdds
-			case 2:
-				oddsString = odds.DrawOdds
-			}
-
-			backFloat, err := strconv.ParseFloat(oddsString, 32)
-			if err == nil {
-				backOdds[i] += backFloat
-				numSites[i]++
-			}
-		}
-	}
+	probabilities, consensus := GetConsensusProbabilities(allOdds, numOutcomes)
+	match.Consensus = &consensus
 
 	for i := 0; i < numOutcomes; i++ {
-		backOdds[i] /= numSites[i]
-		if math.IsNaN(backOdds[i]) {
+		if i >= len(probabilities) || probabilities[i] <= 0 {
 			backOdds[i] = 0
 			layOdds[i] = 0
 			continue
 		}
 
+		backOdds[i] = 1 / probabilities[i]
+
 		layDifference := fnLayDifference(scale) / 2
 
 		layOdds[i] = backOdds[i] + layDifference + addNormalNoise(layDifference)
@@ -90,12 +73,19 @@ func (svc *Service) GetBestOdds(match Match, allOdds []ThreeWayOdd) BestOdds {
 
 // FindBestOdds looks through an event's generated odds so their best odds can be re-used
 func FindBestOdds(match Match) BestOdds {
+	if match.MatchOdds == nil {
+		return BestOdds{}
+	}
+
 	backOdds := match.MatchOdds.Back
 	var bestBackOdds []float64
 	if len(backOdds) > 0 {
 		for _, value := range backOdds {
 			if len(value) > 0 {
 				bestBackOdds = append(bestBackOdds, value[0].Odds)
+			} else {
+				// Keep outcomes aligned when a side of the book is empty
+				bestBackOdds = append(bestBackOdds, 0)
 			}
 		}
 	}
@@ -106,6 +96,8 @@ func FindBestOdds(match Match) BestOdds {
 		for _, value := range layOdds {
 			if len(value) > 0 {
 				bestLayOdds = append(bestLayOdds, value[0].Odds)
+			} else {
+				bestLayOdds = append(bestLayOdds, 0)
 			}
 		}
 	}
@@ -118,12 +110,12 @@ func FindBestOdds(match Match) BestOdds {
 	return bestOdds
 }
 
-// UpdateMatchData generates odds and matched amounts based on the current best odds, randomness and ~maths~
+// UpdateMatchData generates the ladder around the walked best odds and fills the simulated ledger based on the current best odds, randomness and ~maths~
 func (svc *Service) UpdateMatchData(bestOdds BestOdds, match *Match) error {
 	exchangeRate := svc.Internals.PriceDetails.ExchangeRate
-	fnTimeScale := makeSigmoidal([4]float64{0.9968527, 0.2166688, 71743450000, -8.774069})        // Grows to 1 as x -> 0
-	fnMatchedLimit := makeExponential([4]float64{373247800000000000, 7.202931, 0.9016243, -5068}) // Grows to 2e7 as x -> 1
-	fnNumOdds := makeLogistical([4]float64{9.9308, -3.0139, 10.8597, -1.5})
+	fnTimeScale := makeSigmoidal(Pricing.TimeScale)         // Grows to 1 as x -> 0
+	fnMatchedLimit := makeExponential(Pricing.MatchedLimit) // Grows to 2e7 as x -> 1
+	fnNumOdds := makeLogistical(Pricing.NumOdds)
 
 	start, err := strconv.ParseInt(match.StartDate, 10, 64)
 	if err != nil {
@@ -136,22 +128,66 @@ func (svc *Service) UpdateMatchData(bestOdds BestOdds, match *Match) error {
 	}
 	timeScale := fnTimeScale(float64(timeTo))
 
-	if match.MatchOdds != nil && (!isRandSuccess(timeScale) || timeTo == 0) {
-		// Only update a percentage of times or when the match has started
-		return nil
+	svc.ApplySuspensionPolicy(match, timeTo)
+
+	numOdds := fnNumOdds(timeScale+match.Scale) * 1.5
+	matchID := match.ID()
+
+	if timeTo == 0 || match.Suspended {
+		// Freeze the book while the market is suspended or once the match has started, resting orders
+		// can't be filled by a reseed until trading resumes
+		if match.MatchOdds != nil {
+			return nil
+		}
+
+		// A market suspended on a consensus move shows its new prices, its book is rebuilt from them once it reopens
+		matchOdds := svc.GenerateOdds(bestOdds, numOdds, match.Scale, timeScale)
+		svc.Walks.Set(matchID, bestOdds)
+		match.MatchOdds = &matchOdds
+
+		match.Matched, err = svc.GetMatchedVolume(matchID)
+		if err != nil {
+			return err
+		}
+
+		match.SimulatedMatched, err = svc.GetSimulatedVolume(matchID)
+		return err
 	}
 
 	limit := math.Pow(fnMatchedLimit(match.Scale), 0.9)
-	amount := round.AwayFromZero(timeScale*limit*exchangeRate, 1)
-	if amount < 0 {
-		amount = 0
+	target := round.AwayFromZero(timeScale*limit*exchangeRate, 1)
+	if target < 0 {
+		target = 0
+	}
+
+	// Simulated volume only grows, fills move it towards the target curve, it is kept apart from real fills
+	simulated, err := svc.GetSimulatedVolume(matchID)
+	if err != nil {
+		return err
+	}
+
+	simulated, err = svc.AddSimulatedVolume(matchID, SimulateFill(simulated, target, timeScale))
+	if err != nil {
+		return err
 	}
 
-	numOdds := fnNumOdds(timeScale+match.Scale) * 1.5
 	matchOdds := svc.GenerateOdds(bestOdds, numOdds, match.Scale, timeScale)
+	svc.Walks.Set(matchID, bestOdds)
+
+	// The generated ladder becomes resting liquidity, the published ladder is read back from the book
+	fills := svc.OrderBooks.Seed(matchID, matchOdds)
+	ladder := svc.OrderBooks.Ladder(matchID, len(bestOdds.Back))
+
+	matched, err := svc.AddMatchedVolume(matchID, TotalFilled(fills))
+	if err != nil {
+		return err
+	}
+
+	svc.RecordBetFills(fills)
 
-	match.MatchOdds = &matchOdds
-	match.Matched = amount
+	match.MatchOdds = &ladder
+	match.Matched = matched
+	match.SimulatedMatched = simulated
 
 	return nil
 }
@@ -284,7 +320,7 @@ func GetScale(key string) float64 {
 }
 
 func GetTimeScale(unixTime int64) float64 {
-	fnTimeScale := makeSigmoidal([4]float64{1, 0.2851116, 96440480000, -19.12504}) // Grows to 1 as x -> 0
+	fnTimeScale := makeSigmoidal(Pricing.WalkScale) // Grows to 1 as x -> 0
 
 	timeTo := unixTime - time.Now().Unix()
 	if timeTo < 0 {
@@ -151,16 +151,22 @@ type CompetitionInfo struct {
 }
 
 type Match struct {
-	Name            string     `json:"name"`
-	Sport           string     `json:"sport"`
-	CompetitionID   string     `json:"competition"`
-	CompetitionName string     `json:"competition_name"`
-	Participants    []string   `json:"participants"`
-	StartDate       string     `json:"commence"`
-	Outcomes        int        `json:"outcomes"`
-	Matched         float64    `json:"matched"`
-	MatchOdds       *MatchOdds `json:"match_odds"`
-	Scale           float64    `json:"scale"`
+	Name            string   `json:"name"`
+	Sport           string   `json:"sport"`
+	CompetitionID   string   `json:"competition"`
+	CompetitionName string   `json:"competition_name"`
+	Participants    []string `json:"participants"`
+	StartDate       string   `json:"commence"`
+	Outcomes        int      `json:"outcomes"`
+	Matched         float64  `json:"matched"`
+	// Volume generated by the market simulation, never included in Matched
+	SimulatedMatched float64    `json:"simulated_matched"`
+	MatchOdds        *MatchOdds `json:"match_odds"`
+	Scale            float64    `json:"scale"`
+	Consensus        *Consensus `json:"consensus,omitempty"`
+	Suspended        bool       `json:"suspended"`
+	SuspendedReason  string     `json:"suspended_reason,omitempty"`
+	SuspendedUntil   int64      `json:"suspended_until,omitempty"`
 }
 
 type ByDate []Match
@@ -254,6 +260,7 @@ type BlockInfoResponse struct {
 	AverageBlockTime float64 `json:"average_time"`
 	BlockHeight      int64   `json:"block_height"`
 	UpdatedAt        int64   `json:"updated_at"`
+	ChainStalled     bool    `json:"chain-stalled"`
 }
 
 type SportDetail struct {
@@ -509,6 +516,20 @@ func (o ThreeWayOdd) IsEmpty() bool {
 	return reflect.DeepEqual(o, ThreeWayOdd{})
 }
 
+// GetOutcome returns the odds for an outcome index, ordered home, away then draw
+func (o ThreeWayOdd) GetOutcome(outcome int) string {
+	switch outcome {
+	case 0:
+		return o.HomeOdds
+	case 1:
+		return o.AwayOdds
+	case 2:
+		return o.DrawOdds
+	default:
+		return ""
+	}
+}
+
 // If we want to unmarshal cleanly we tag each individual sport
 type ThreeWayOddsA struct {
 	SoccerOdds     ThreeWayOdd `json:"1_1"`
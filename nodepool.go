@@ -0,0 +1,306 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/parnurzeal/gorequest"
+)
+
+// Node pool health thresholds
+var (
+	// Timeout for a single RPC call to a node
+	NodeTimeout = 5 * time.Second
+	// Nodes further than this many blocks behind the best height are quarantined
+	NodeLagLimit = int64(2)
+	// Nodes with an error rate above this are quarantined
+	NodeMaxErrorRate = 0.5
+	// How long a quarantined node is left out of selection
+	NodeQuarantineTime = 2 * time.Minute
+	// Weight of the latest request in the latency and error rate moving averages
+	NodeStatsWeight = 0.2
+)
+
+type Node struct {
+	URI              string    `json:"uri"`
+	Height           int64     `json:"height"`
+	Latency          float64   `json:"latency_ms"`
+	ErrorRate        float64   `json:"error_rate"`
+	Requests         int64     `json:"requests"`
+	Errors           int64     `json:"errors"`
+	LastError        string    `json:"last_error,omitempty"`
+	LastChecked      time.Time `json:"last_checked"`
+	QuarantinedUntil time.Time `json:"quarantined_until"`
+	QuarantineReason string    `json:"quarantine_reason,omitempty"`
+}
+
+// NodePool tracks the health of every known NEO node and serves calls from the best one
+type NodePool struct {
+	mutex   sync.Mutex
+	nodes   []*Node
+	current *Node
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+	ID      int           `json:"id"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func NewNodePool(nodeURIs []string) *NodePool {
+	pool := &NodePool{}
+	for _, uri := range nodeURIs {
+		pool.nodes = append(pool.nodes, &Node{URI: uri})
+	}
+
+	if len(pool.nodes) > 0 {
+		pool.current = pool.nodes[0]
+	}
+
+	return pool
+}
+
+// Call makes a JSON-RPC call against the selected node, reselecting once and retrying if it fails
+func (p *NodePool) Call(method string, params []interface{}, result interface{}) error {
+	node := p.Current()
+	if node == nil {
+		return fmt.Errorf("No nodes available")
+	}
+
+	err := p.call(node, method, params, result)
+	if err == nil {
+		return nil
+	}
+
+	p.Probe()
+	retryNode := p.Select()
+	if retryNode == nil || retryNode == node {
+		return err
+	}
+
+	return p.call(retryNode, method, params, result)
+}
+
+// GetBlockCount returns the block count of the selected node
+func (p *NodePool) GetBlockCount() (int64, error) {
+	var count int64
+	err := p.Call("getblockcount", []interface{}{}, &count)
+	return count, err
+}
+
+func (p *NodePool) Current() *Node {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.current
+}
+
+// Probe asks every node that isn't quarantined for its height, updating its latency and error stats
+func (p *NodePool) Probe() {
+	var wg sync.WaitGroup
+
+	now := time.Now()
+	var nodes []*Node
+
+	p.mutex.Lock()
+	for _, node := range p.nodes {
+		if now.Before(node.QuarantinedUntil) {
+			continue
+		}
+
+		// Released nodes start again with a clean error rate
+		if node.QuarantineReason != "" {
+			node.QuarantineReason = ""
+			node.ErrorRate = 0
+		}
+
+		nodes = append(nodes, node)
+	}
+	p.mutex.Unlock()
+
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+
+			var count int64
+			p.call(node, "getblockcount", []interface{}{}, &count)
+		}(node)
+	}
+
+	wg.Wait()
+}
+
+// Select quarantines lagging or failing nodes and picks the highest healthy node, preferring lower latency
+func (p *NodePool) Select() *Node {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+
+	bestHeight := int64(0)
+	for _, node := range p.nodes {
+		if node.Height > bestHeight && now.After(node.QuarantinedUntil) {
+			bestHeight = node.Height
+		}
+	}
+
+	var healthy []*Node
+	for _, node := range p.nodes {
+		if now.Before(node.QuarantinedUntil) {
+			continue
+		}
+
+		switch {
+		case node.Requests > 0 && node.ErrorRate > NodeMaxErrorRate:
+			p.quarantine(node, fmt.Sprintf("error rate %.2f", node.ErrorRate))
+		case bestHeight-node.Height > NodeLagLimit:
+			p.quarantine(node, fmt.Sprintf("%d blocks behind", bestHeight-node.Height))
+		default:
+			healthy = append(healthy, node)
+		}
+	}
+
+	if len(healthy) < 1 {
+		return p.current
+	}
+
+	sort.SliceStable(healthy, func(i, j int) bool {
+		if healthy[i].Height == healthy[j].Height {
+			return healthy[i].Latency < healthy[j].Latency
+		}
+		return healthy[i].Height > healthy[j].Height
+	})
+
+	p.current = healthy[0]
+	return p.current
+}
+
+// BestHeight returns the highest block count reported by any node
+func (p *NodePool) BestHeight() int64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	bestHeight := int64(0)
+	for _, node := range p.nodes {
+		if node.Height > bestHeight {
+			bestHeight = node.Height
+		}
+	}
+
+	return bestHeight
+}
+
+// State returns a copy of the pool for reporting
+func (p *NodePool) State() ChainState {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	state := ChainState{
+		Backend: "neo",
+		Nodes:   []Node{},
+	}
+
+	if p.current != nil {
+		state.Current = p.current.URI
+	}
+
+	for _, node := range p.nodes {
+		state.Nodes = append(state.Nodes, *node)
+		if node.Height > state.Height {
+			state.Height = node.Height
+		}
+	}
+
+	return state
+}
+
+func (p *NodePool) quarantine(node *Node, reason string) {
+	node.QuarantinedUntil = time.Now().Add(NodeQuarantineTime)
+	node.QuarantineReason = reason
+}
+
+func (p *NodePool) call(node *Node, method string, params []interface{}, result interface{}) error {
+	start := time.Now()
+	err := callNode(node.URI, method, params, result)
+	latency := float64(time.Since(start)) / float64(time.Millisecond)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	node.Requests++
+	node.LastChecked = time.Now()
+
+	if node.Latency == 0 {
+		node.Latency = latency
+	} else {
+		node.Latency += NodeStatsWeight * (latency - node.Latency)
+	}
+
+	failure := float64(0)
+	if err != nil {
+		failure = 1
+		node.Errors++
+		node.LastError = err.Error()
+	}
+	node.ErrorRate += NodeStatsWeight * (failure - node.ErrorRate)
+
+	if err == nil && method == "getblockcount" {
+		if count, ok := result.(*int64); ok {
+			node.Height = *count
+		}
+	}
+
+	return err
+}
+
+func callNode(uri, method string, params []interface{}, result interface{}) error {
+	request := rpcRequest{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  params,
+		ID:      1,
+	}
+
+	var response rpcResponse
+	_, _, errs := gorequest.New().
+		Timeout(NodeTimeout).
+		Post(uri).
+		Send(request).
+		EndStruct(&response)
+	if errs != nil {
+		return aggregateErrors(fmt.Sprintf("Unable to call %s on %s", method, uri), errs)
+	}
+
+	if response.Error != nil {
+		return fmt.Errorf("%s on %s: %s", method, uri, response.Error.Message)
+	}
+
+	return json.Unmarshal(response.Result, result)
+}
+
+// GetBlockHeader fetches a block's header by index from the selected node
+func (p *NodePool) GetBlockHeader(index int64) (header BlockHeader, err error) {
+	err = p.Call("getblock", []interface{}{index, 1}, &header)
+	return
+}
+
+// Refresh probes every node and reselects the best one
+func (p *NodePool) Refresh() {
+	p.Probe()
+	p.Select()
+}
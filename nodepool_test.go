@@ -0,0 +1,115 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestNode answers getblockcount at a fixed height, or with an RPC error when failing
+func newTestNode(height int64, failing bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request rpcRequest
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if failing {
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": rpcError{Code: -1, Message: "node down"}})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": height})
+	}))
+}
+
+func TestNodePoolSelect(t *testing.T) {
+	pool := NewNodePool(nil)
+	pool.nodes = []*Node{
+		{URI: "slow", Height: 100, Latency: 50},
+		{URI: "fast", Height: 100, Latency: 20},
+		{URI: "lagging", Height: 97, Latency: 5},
+		{URI: "failing", Height: 100, Latency: 5, Requests: 10, ErrorRate: 0.8},
+		// Left out of the best height while quarantined
+		{URI: "quarantined", Height: 120, QuarantinedUntil: time.Now().Add(time.Minute)},
+	}
+
+	if selected := pool.Select(); selected == nil || selected.URI != "fast" {
+		t.Fatalf("selected %+v, expected fast", selected)
+	}
+
+	tests := []struct {
+		uri         string
+		quarantined bool
+	}{
+		{"slow", false},
+		{"fast", false},
+		{"lagging", true},
+		{"failing", true},
+		{"quarantined", true},
+	}
+
+	for i, test := range tests {
+		node := pool.nodes[i]
+		quarantined := time.Now().Before(node.QuarantinedUntil)
+		if quarantined != test.quarantined {
+			t.Errorf("%s: quarantined = %t (%s), expected %t", test.uri, quarantined, node.QuarantineReason, test.quarantined)
+		}
+	}
+
+	// With every node quarantined the current one is kept
+	for _, node := range pool.nodes {
+		pool.quarantine(node, "test")
+	}
+	if selected := pool.Select(); selected == nil || selected.URI != "fast" {
+		t.Errorf("selected %+v with no healthy nodes, expected fast to be kept", selected)
+	}
+}
+
+func TestNodePoolCallFailsOver(t *testing.T) {
+	down := newTestNode(0, true)
+	defer down.Close()
+	up := newTestNode(42, false)
+	defer up.Close()
+
+	pool := NewNodePool([]string{down.URL, up.URL})
+
+	count, err := pool.GetBlockCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 42 || pool.Current().URI != up.URL {
+		t.Errorf("got %d from %s, expected 42 from %s", count, pool.Current().URI, up.URL)
+	}
+
+	state := pool.State()
+	failed := state.Nodes[0]
+	if failed.Errors != 2 || failed.ErrorRate <= 0 || failed.LastError == "" {
+		t.Errorf("failed node stats = %+v", failed)
+	}
+	if state.Height != 42 || state.Nodes[1].Requests != 2 {
+		t.Errorf("pool state = %+v", state)
+	}
+}
+
+func TestProbeReleasesQuarantine(t *testing.T) {
+	server := newTestNode(42, false)
+	defer server.Close()
+
+	pool := NewNodePool([]string{server.URL})
+	node := pool.nodes[0]
+
+	// Still quarantined, so it isn't probed
+	pool.quarantine(node, "error rate 1.00")
+	node.ErrorRate = 1
+	pool.Probe()
+	if node.Requests != 0 {
+		t.Fatalf("quarantined node probed %d times", node.Requests)
+	}
+
+	node.QuarantinedUntil = time.Now().Add(-time.Second)
+	pool.Probe()
+	if node.QuarantineReason != "" || node.ErrorRate != 0 || node.Height != 42 {
+		t.Errorf("released node = %+v, expected a clean error rate at height 42", node)
+	}
+}
@@ -0,0 +1,439 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/a-h/round"
+	"github.com/gorilla/mux"
+)
+
+const (
+	SideBack = "back"
+	SideLay  = "lay"
+)
+
+// Number of price levels shown on each side of a ladder
+const MaxLadderDepth = 7
+
+// Remaining stakes below this are treated as fully matched
+const minStake = 0.01
+
+type Order struct {
+	ID        string  `json:"id"`
+	MatchID   string  `json:"match_id"`
+	Outcome   int     `json:"outcome"`
+	Side      string  `json:"side"`
+	Odds      float64 `json:"odds"`
+	Stake     float64 `json:"stake"`
+	Remaining float64 `json:"remaining"`
+	Simulated bool    `json:"simulated"`
+	// Bet the order was placed for, fills against it while resting are added to the bet
+	BetID     string `json:"bet_id,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	sequence  int64
+}
+
+type Fill struct {
+	BackOrderID string  `json:"back_order_id"`
+	LayOrderID  string  `json:"lay_order_id"`
+	BackBetID   string  `json:"back_bet_id,omitempty"`
+	LayBetID    string  `json:"lay_bet_id,omitempty"`
+	Odds        float64 `json:"odds"`
+	Stake       float64 `json:"stake"`
+	Timestamp   int64   `json:"timestamp"`
+}
+
+// OrderBook holds the resting orders for a single match outcome
+type OrderBook struct {
+	Backs []*Order // Lowest odds first, then earliest
+	Lays  []*Order // Highest odds first, then earliest
+}
+
+// OrderBookStore keeps an in-memory order book per match outcome
+type OrderBookStore struct {
+	mutex    sync.Mutex
+	books    map[string][]*OrderBook
+	sequence int64
+}
+
+func NewOrderBookStore() *OrderBookStore {
+	return &OrderBookStore{
+		books: make(map[string][]*OrderBook),
+	}
+}
+
+// Place submits a limit order, matching it against the opposite side with price-time priority and resting any remainder
+func (s *OrderBookStore) Place(order Order) (Order, []Fill, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.place(order)
+}
+
+func (s *OrderBookStore) place(order Order) (Order, []Fill, error) {
+	if order.Side != SideBack && order.Side != SideLay {
+		return order, nil, fmt.Errorf("Unknown order side: %s", order.Side)
+	}
+
+	if order.Odds < MinOdds || order.Odds > MaxOdds {
+		return order, nil, fmt.Errorf("Odds must be between %v and %v", MinOdds, MaxOdds)
+	}
+
+	// Fills are rounded to 2dp, so an unrounded stake could be filled for more than was staked
+	order.Stake = round.AwayFromZero(order.Stake, 2)
+	if order.Stake < minStake {
+		return order, nil, errors.New("Stake is too small")
+	}
+
+	s.sequence++
+	order.sequence = s.sequence
+	order.ID = fmt.Sprintf("%s-%d", order.MatchID, s.sequence)
+	order.Odds = round.AwayFromZero(order.Odds, 2)
+	order.Remaining = order.Stake
+	order.CreatedAt = time.Now().Unix()
+
+	book := s.getBook(order.MatchID, order.Outcome)
+	fills := book.match(&order)
+
+	if order.Remaining >= minStake {
+		resting := order
+		book.rest(&resting)
+	}
+
+	return order, fills, nil
+}
+
+// Seed replaces a match's simulated liquidity with the generated ladder, any fills against real orders are returned
+func (s *OrderBookStore) Seed(matchID string, matchOdds MatchOdds) []Fill {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var fills []Fill
+
+	for outcome := range matchOdds.Back {
+		s.getBook(matchID, outcome).removeSimulated()
+	}
+	for outcome := range matchOdds.Lay {
+		s.getBook(matchID, outcome).removeSimulated()
+	}
+
+	// Prices available to back are resting lays and vice versa
+	seedMap := map[string][][]Odds{
+		SideLay:  matchOdds.Back,
+		SideBack: matchOdds.Lay,
+	}
+
+	for side, ladders := range seedMap {
+		for outcome, ladder := range ladders {
+			for _, level := range ladder {
+				_, newFills, err := s.place(Order{
+					MatchID:   matchID,
+					Outcome:   outcome,
+					Side:      side,
+					Odds:      level.Odds,
+					Stake:     level.Available,
+					Simulated: true,
+				})
+				if err != nil {
+					continue
+				}
+
+				fills = append(fills, newFills...)
+			}
+		}
+	}
+
+	return fills
+}
+
+// Ladder aggregates the resting orders of a match into its back and lay ladders
+func (s *OrderBookStore) Ladder(matchID string, numOutcomes int) MatchOdds {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	matchOdds := MatchOdds{
+		Back: make([][]Odds, numOutcomes),
+		Lay:  make([][]Odds, numOutcomes),
+	}
+
+	for outcome := 0; outcome < numOutcomes; outcome++ {
+		book := s.getBook(matchID, outcome)
+		matchOdds.Back[outcome] = aggregateOrders(book.Lays)
+		matchOdds.Lay[outcome] = aggregateOrders(book.Backs)
+	}
+
+	return matchOdds
+}
+
+// Cancel removes a resting order from its book, reporting whether it was still resting
+func (s *OrderBookStore) Cancel(matchID string, outcome int, orderID string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if outcome < 0 || outcome >= len(s.books[matchID]) {
+		return false
+	}
+
+	book := s.books[matchID][outcome]
+	for _, side := range []*[]*Order{&book.Backs, &book.Lays} {
+		for i, order := range *side {
+			if order.ID == orderID {
+				*side = append((*side)[:i], (*side)[i+1:]...)
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Prune drops the books of any match that is no longer active
+func (s *OrderBookStore) Prune(active map[string]Match) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for matchID := range s.books {
+		if _, ok := active[matchID]; !ok {
+			delete(s.books, matchID)
+		}
+	}
+}
+
+func (s *OrderBookStore) getBook(matchID string, outcome int) *OrderBook {
+	books := s.books[matchID]
+	for len(books) <= outcome {
+		books = append(books, &OrderBook{})
+	}
+	s.books[matchID] = books
+
+	return books[outcome]
+}
+
+func (b *OrderBook) match(order *Order) []Fill {
+	var fills []Fill
+
+	opposite := &b.Backs
+	if order.Side == SideBack {
+		opposite = &b.Lays
+	}
+
+	for len(*opposite) > 0 && order.Remaining >= minStake {
+		resting := (*opposite)[0]
+
+		// Backers take lays at their price or better (higher), layers take backs at their price or lower
+		if order.Side == SideBack && resting.Odds < order.Odds {
+			break
+		} else if order.Side == SideLay && resting.Odds > order.Odds {
+			break
+		}
+
+		stake := round.AwayFromZero(minFloat(order.Remaining, resting.Remaining), 2)
+
+		fill := Fill{
+			Odds:      resting.Odds,
+			Stake:     stake,
+			Timestamp: time.Now().Unix(),
+		}
+
+		if order.Side == SideBack {
+			fill.BackOrderID, fill.LayOrderID = order.ID, resting.ID
+			fill.BackBetID, fill.LayBetID = order.BetID, resting.BetID
+		} else {
+			fill.BackOrderID, fill.LayOrderID = resting.ID, order.ID
+			fill.BackBetID, fill.LayBetID = resting.BetID, order.BetID
+		}
+
+		fills = append(fills, fill)
+
+		order.Remaining = round.AwayFromZero(order.Remaining-stake, 2)
+		resting.Remaining = round.AwayFromZero(resting.Remaining-stake, 2)
+
+		if resting.Remaining < minStake {
+			*opposite = (*opposite)[1:]
+		}
+	}
+
+	return fills
+}
+
+func (b *OrderBook) rest(order *Order) {
+	if order.Side == SideBack {
+		b.Backs = append(b.Backs, order)
+		sort.SliceStable(b.Backs, func(i, j int) bool {
+			if b.Backs[i].Odds == b.Backs[j].Odds {
+				return b.Backs[i].sequence < b.Backs[j].sequence
+			}
+			return b.Backs[i].Odds < b.Backs[j].Odds
+		})
+	} else {
+		b.Lays = append(b.Lays, order)
+		sort.SliceStable(b.Lays, func(i, j int) bool {
+			if b.Lays[i].Odds == b.Lays[j].Odds {
+				return b.Lays[i].sequence < b.Lays[j].sequence
+			}
+			return b.Lays[i].Odds > b.Lays[j].Odds
+		})
+	}
+}
+
+func (b *OrderBook) removeSimulated() {
+	b.Backs = filterReal(b.Backs)
+	b.Lays = filterReal(b.Lays)
+}
+
+func filterReal(orders []*Order) []*Order {
+	var real []*Order
+	for _, order := range orders {
+		if !order.Simulated {
+			real = append(real, order)
+		}
+	}
+
+	return real
+}
+
+// aggregateOrders groups sorted resting orders into price levels
+func aggregateOrders(orders []*Order) []Odds {
+	levels := []Odds{}
+
+	for _, order := range orders {
+		last := len(levels) - 1
+		if last >= 0 && levels[last].Odds == order.Odds {
+			levels[last].Available = round.AwayFromZero(levels[last].Available+order.Remaining, 2)
+			continue
+		}
+
+		if len(levels) >= MaxLadderDepth {
+			break
+		}
+
+		levels = append(levels, Odds{
+			Odds:      order.Odds,
+			Available: order.Remaining,
+		})
+	}
+
+	return levels
+}
+
+// TotalFilled sums the stake of a list of fills
+func TotalFilled(fills []Fill) float64 {
+	total := float64(0)
+	for _, fill := range fills {
+		total += fill.Stake
+	}
+
+	return total
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// OrderRequest is a limit order placed for an account, its liability is reserved like any other bet
+type OrderRequest struct {
+	AccountID string  `json:"account_id"`
+	Outcome   int     `json:"outcome"`
+	Side      string  `json:"side"`
+	Odds      float64 `json:"odds"`
+	Stake     float64 `json:"stake"`
+}
+
+type OrderResponse struct {
+	Order Order  `json:"order"`
+	Fills []Fill `json:"fills"`
+	Bet   Bet    `json:"bet"`
+}
+
+// PlaceOrder places a limit order for an account at its own price
+func (svc *Service) PlaceOrder(matchID string, request OrderRequest) (OrderResponse, error) {
+	if request.AccountID == "" {
+		return OrderResponse{}, errors.New("An account is required to place orders")
+	}
+
+	match, err := svc.GetMatch(matchID)
+	if err != nil {
+		return OrderResponse{}, err
+	}
+
+	return svc.placeBet(request.AccountID, match, request.Outcome, request.Side, request.Odds, request.Stake)
+}
+
+// submitOrder submits an order against a match's book and records any fills on the matched ledger
+func (svc *Service) submitOrder(match Match, order Order) (OrderResponse, error) {
+	matchID := match.ID()
+
+	if order.Outcome < 0 || order.Outcome >= match.Outcomes {
+		return OrderResponse{}, fmt.Errorf("Invalid outcome %d for match %s", order.Outcome, matchID)
+	}
+
+	if match.Suspended {
+		return OrderResponse{}, fmt.Errorf("Market is suspended: %s", match.SuspendedReason)
+	}
+
+	order.MatchID = matchID
+	order, fills, err := svc.OrderBooks.Place(order)
+	if err != nil {
+		return OrderResponse{}, err
+	}
+
+	_, err = svc.AddMatchedVolume(matchID, TotalFilled(fills))
+	if err != nil {
+		return OrderResponse{}, err
+	}
+
+	go svc.PushMarketUpdates([]Match{match})
+
+	if fills == nil {
+		fills = []Fill{}
+	}
+
+	return OrderResponse{
+		Order: order,
+		Fills: fills,
+	}, nil
+}
+
+func (svc *Service) PlaceOrderHandler(w http.ResponseWriter, r *http.Request) {
+	matchID := mux.Vars(r)["id"]
+
+	var request OrderRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid order", http.StatusBadRequest)
+		return
+	}
+
+	response, err := svc.PlaceOrder(matchID, request)
+	if err == ErrAccountNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func (svc *Service) OrderBookHandler(w http.ResponseWriter, r *http.Request) {
+	matchID := mux.Vars(r)["id"]
+
+	match, err := svc.GetMatch(matchID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(svc.OrderBooks.Ladder(matchID, match.Outcomes))
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// storeTestMatch lists a match with a seeded book so orders and bets can be placed against it
+func storeTestMatch(t *testing.T, svc *Service) Match {
+	match := Match{
+		Name:      "Home v Away",
+		StartDate: strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10),
+		Outcomes:  2,
+		MatchOdds: &MatchOdds{
+			Back: [][]Odds{{{Odds: 2, Available: 100}}, {{Odds: 2, Available: 100}}},
+			Lay:  [][]Odds{{{Odds: 2.1, Available: 100}}, {{Odds: 2.1, Available: 100}}},
+		},
+	}
+
+	svc.OrderBooks.Seed(match.ID(), *match.MatchOdds)
+
+	err := svc.SetRedis("all-matches", []Match{match})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return match
+}
+
+func createTestAccount(t *testing.T, svc *Service, balance float64) Account {
+	account, err := svc.CreateAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, err = svc.CreditAccount(account.ID, CreditRequest{Amount: balance, Currency: "GAS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return account
+}
+
+func TestPlaceOrderRequiresAccount(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	match := storeTestMatch(t, svc)
+
+	_, err := svc.PlaceOrder(match.ID(), OrderRequest{Outcome: 0, Side: SideBack, Odds: 3, Stake: 10})
+	if err == nil {
+		t.Fatal("order placed without an account")
+	}
+}
+
+func TestPlaceOrderReservesLiability(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	match := storeTestMatch(t, svc)
+	account := createTestAccount(t, svc, 100)
+
+	tests := []struct {
+		request  OrderRequest
+		balance  float64
+		reserved float64
+		matched  float64
+		err      bool
+	}{
+		// Rests below the best back price so nothing is matched
+		{OrderRequest{Outcome: 0, Side: SideLay, Odds: 1.5, Stake: 10}, 95, 5, 0, false},
+		// Takes the liquidity available to back at 2
+		{OrderRequest{Outcome: 1, Side: SideBack, Odds: 2, Stake: 20}, 75, 25, 20, false},
+		// Liability of 100 is more than the remaining balance
+		{OrderRequest{Outcome: 0, Side: SideLay, Odds: 2, Stake: 100}, 75, 25, 0, true},
+	}
+
+	for i, test := range tests {
+		test.request.AccountID = account.ID
+		response, err := svc.PlaceOrder(match.ID(), test.request)
+		if (err != nil) != test.err {
+			t.Fatalf("order %d error = %v", i, err)
+		}
+
+		if err == nil {
+			if response.Bet.OrderID != response.Order.ID {
+				t.Errorf("order %d bet is for order %s, expected %s", i, response.Bet.OrderID, response.Order.ID)
+			}
+
+			if response.Bet.Matched != test.matched {
+				t.Errorf("order %d matched = %f, expected %f", i, response.Bet.Matched, test.matched)
+			}
+		}
+
+		updated, err := svc.GetAccount(account.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if updated.Balance != test.balance || updated.Reserved != test.reserved {
+			t.Errorf("order %d balance = %f reserved = %f, expected %f and %f", i, updated.Balance, updated.Reserved, test.balance, test.reserved)
+		}
+	}
+}
+
+func TestUpdateMatchDataKeepsSimulatedVolumeApart(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	svc.Internals.PriceDetails.ExchangeRate = 1
+
+	match := Match{
+		Name:      "Home v Away",
+		StartDate: strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10),
+		Outcomes:  2,
+		Scale:     1,
+	}
+
+	for i := 0; i < 5; i++ {
+		err := svc.UpdateMatchData(BestOdds{Back: []float64{2, 2}, Lay: []float64{2.1, 2.1}}, &match)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	simulated, err := svc.GetSimulatedVolume(match.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if match.Matched != 0 {
+		t.Errorf("matched = %f with no real orders", match.Matched)
+	}
+
+	if match.SimulatedMatched != simulated {
+		t.Errorf("simulated matched = %f, ledger has %f", match.SimulatedMatched, simulated)
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	store := NewOrderBookStore()
+
+	order, _, err := store.Place(Order{MatchID: "match", Outcome: 1, Side: SideLay, Odds: 2, Stake: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		matchID   string
+		outcome   int
+		cancelled bool
+	}{
+		{"other", 1, false},
+		{"match", 0, false},
+		{"match", 1, true},
+		// Already gone
+		{"match", 1, false},
+	}
+
+	for _, test := range tests {
+		if cancelled := store.Cancel(test.matchID, test.outcome, order.ID); cancelled != test.cancelled {
+			t.Errorf("cancel on %s outcome %d = %t, expected %t", test.matchID, test.outcome, cancelled, test.cancelled)
+		}
+	}
+
+	if ladder := store.Ladder("match", 2); len(ladder.Back[1]) != 0 {
+		t.Errorf("cancelled order still offered: %+v", ladder.Back[1])
+	}
+}
+
+func TestPlaceRoundsStake(t *testing.T) {
+	store := NewOrderBookStore()
+
+	tests := []struct {
+		stake    float64
+		expected float64
+		err      bool
+	}{
+		{1.006, 1.01, false},
+		{10.004, 10, false},
+		{0.004, 0, true},
+	}
+
+	for _, test := range tests {
+		order, _, err := store.Place(Order{MatchID: "match", Side: SideBack, Odds: 2, Stake: test.stake})
+		if (err != nil) != test.err {
+			t.Fatalf("stake %v error = %v", test.stake, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		if order.Stake != test.expected || order.Remaining != test.expected {
+			t.Errorf("stake %v placed as %v with %v remaining, expected %v", test.stake, order.Stake, order.Remaining, test.expected)
+		}
+	}
+}
+
+func TestUpdateMatchDataFreezesSuspendedBook(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	svc.Internals.PriceDetails.ExchangeRate = 1
+
+	// Suspended on a consensus move, so the scheduler dropped the old ladder
+	match := Match{
+		Name:           "Home v Away",
+		StartDate:      strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10),
+		Outcomes:       2,
+		Scale:          1,
+		SuspendedUntil: time.Now().Add(time.Minute).Unix(),
+	}
+	account := createTestAccount(t, svc, 100)
+
+	err := svc.SetRedis("all-matches", []Match{match})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Would be crossed by a book seeded at the new prices
+	resting, err := svc.PlaceOrder(match.ID(), OrderRequest{AccountID: account.ID, Outcome: 0, Side: SideLay, Odds: 10, Stake: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = svc.UpdateMatchData(BestOdds{Back: []float64{2, 2}, Lay: []float64{2.1, 2.1}}, &match)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !match.Suspended || match.MatchOdds == nil {
+		t.Fatalf("suspended = %t with ladder %v, expected the new prices published while suspended", match.Suspended, match.MatchOdds)
+	}
+
+	var bet Bet
+	err = svc.GetRedis(BetKey(resting.Bet.ID), &bet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bet.Matched != 0 {
+		t.Errorf("resting bet matched %f while the market was suspended", bet.Matched)
+	}
+
+	if ladder := svc.OrderBooks.Ladder(match.ID(), 2); len(ladder.Lay[0]) != 0 || len(ladder.Back[0]) != 1 {
+		t.Errorf("book was reseeded while suspended: %+v", ladder)
+	}
+}
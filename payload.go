@@ -0,0 +1,171 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Largest encoded payload sent as a single message, Pusher rejects data over 10KB
+// so this leaves room for the JSON quoting and event metadata
+var MaxPayloadSize = 10000
+
+// What to do with oversized app updates, chunk splits them and trim drops matches from the end
+// of the list until they fit, other payloads are always chunked
+var PayloadOversizePolicy = os.Getenv("PAYLOAD_OVERSIZE_POLICY")
+
+const (
+	PayloadChunk = "chunk"
+	PayloadTrim  = "trim"
+)
+
+// Room left in each chunk for the chunk metadata
+const chunkOverhead = 200
+
+// Upper bounds in bytes of the payload size buckets recorded for each channel
+var PayloadSizeBuckets = []int{1000, 2000, 5000, 10000, 20000, 50000}
+
+// PayloadChunkMessage is sent as <event>-chunk, clients join Data from every chunk with the same ID
+// in Index order and decode the result as they would the original event
+type PayloadChunkMessage struct {
+	ID    string `json:"id"`
+	Index int    `json:"index"`
+	Total int    `json:"total"`
+	Data  string `json:"data"`
+}
+
+// Channels with payload sizes recorded
+const PayloadChannelsKey = "payload-channels"
+
+func PayloadSizesKey(channelName string) string {
+	return "payload-sizes-" + channelName
+}
+
+// OversizedEvents trims or chunks a payload that's over MaxPayloadSize
+func (svc *Service) OversizedEvents(messageData interface{}, encodedData, channelName, eventName string) ([]PublishEvent, error) {
+	if message, ok := messageData.(AppUpdateMessage); ok && PayloadOversizePolicy == PayloadTrim {
+		trimmedData, removed, err := TrimAppUpdate(message)
+		if err != nil {
+			return nil, err
+		}
+
+		svc.Logger.Log("msg", fmt.Sprintf("Trimmed %d matches from %s to fit payload limit", removed, channelName))
+		return []PublishEvent{{Channel: channelName, Event: eventName, Data: trimmedData}}, nil
+	}
+
+	chunks, err := ChunkPayload(encodedData)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []PublishEvent
+	for _, chunk := range chunks {
+		events = append(events, PublishEvent{Channel: channelName, Event: eventName + "-chunk", Data: chunk})
+	}
+
+	return events, nil
+}
+
+// TrimAppUpdate drops matches from the end of the list until the encoded message fits
+func TrimAppUpdate(message AppUpdateMessage) (encodedData string, removed int, err error) {
+	matches := message.Matches
+	for len(matches) > 0 {
+		matches = matches[:len(matches)-1]
+		removed++
+
+		message.Matches = matches
+		encodedData, err = EncodeData(message)
+		if err != nil || len(encodedData) <= MaxPayloadSize {
+			return
+		}
+	}
+
+	return encodedData, removed, fmt.Errorf("Payload too large without any matches: %d bytes", len(encodedData))
+}
+
+// ChunkPayload splits an encoded payload into ordered chunks that each fit in a message
+func ChunkPayload(encodedData string) ([]PayloadChunkMessage, error) {
+	id, err := generateRandomID()
+	if err != nil {
+		return nil, err
+	}
+
+	size := MaxPayloadSize - chunkOverhead
+	total := (len(encodedData) + size - 1) / size
+
+	var chunks []PayloadChunkMessage
+	for index := 0; index < total; index++ {
+		end := (index + 1) * size
+		if end > len(encodedData) {
+			end = len(encodedData)
+		}
+
+		chunks = append(chunks, PayloadChunkMessage{
+			ID:    id,
+			Index: index,
+			Total: total,
+			Data:  encodedData[index*size : end],
+		})
+	}
+
+	return chunks, nil
+}
+
+// RecordPayloadSize counts an encoded payload against its size bucket for the channel
+func (svc *Service) RecordPayloadSize(channelName string, size int) {
+	bucket := fmt.Sprintf(">%d", PayloadSizeBuckets[len(PayloadSizeBuckets)-1])
+	for _, limit := range PayloadSizeBuckets {
+		if size <= limit {
+			bucket = "<=" + strconv.Itoa(limit)
+			break
+		}
+	}
+
+	key := PayloadSizesKey(channelName)
+
+	pipe := svc.RedisClient.TxPipeline()
+	pipe.SAdd(PayloadChannelsKey, channelName)
+	pipe.HIncrBy(key, bucket, 1)
+	pipe.HIncrBy(key, "count", 1)
+	pipe.HIncrBy(key, "total", int64(size))
+	if size > MaxPayloadSize {
+		pipe.HIncrBy(key, "oversized", 1)
+	}
+	_, err := pipe.Exec()
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		return
+	}
+
+	// Keep the largest payload seen
+	max, err := svc.RedisClient.HGet(key, "max").Int64()
+	if err == nil && max >= int64(size) {
+		return
+	}
+
+	err = svc.RedisClient.HSet(key, "max", size).Err()
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+	}
+}
+
+func (svc *Service) PayloadSizesHandler(w http.ResponseWriter, r *http.Request) {
+	channels, err := svc.RedisClient.SMembers(PayloadChannelsKey).Result()
+	if err != nil {
+		http.Error(w, "Unable to fetch payload sizes", http.StatusInternalServerError)
+		return
+	}
+
+	sizes := make(map[string]map[string]string)
+	for _, channel := range channels {
+		sizes[channel], err = svc.RedisClient.HGetAll(PayloadSizesKey(channel)).Result()
+		if err != nil {
+			http.Error(w, "Unable to fetch payload sizes", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(sizes)
+}
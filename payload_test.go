@@ -0,0 +1,187 @@
+package service
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func decodeTestData(t *testing.T, encodedData string, v interface{}) {
+	compressed, err := base64.StdEncoding.DecodeString(encodedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataJSON, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(dataJSON, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChunkPayload(t *testing.T) {
+	defer func(size int) { MaxPayloadSize = size }(MaxPayloadSize)
+	MaxPayloadSize = chunkOverhead + 100
+
+	tests := []struct {
+		size   int
+		chunks int
+	}{
+		{0, 0},
+		{1, 1},
+		{100, 1},
+		{101, 2},
+		{250, 3},
+	}
+
+	for _, test := range tests {
+		data := strings.Repeat("x", test.size)
+
+		chunks, err := ChunkPayload(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chunks) != test.chunks {
+			t.Errorf("%d bytes: %d chunks, expected %d", test.size, len(chunks), test.chunks)
+			continue
+		}
+
+		joined := ""
+		for i, chunk := range chunks {
+			if chunk.ID != chunks[0].ID || chunk.Index != i || chunk.Total != test.chunks {
+				t.Errorf("%d bytes: chunk %d is %s %d of %d", test.size, i, chunk.ID, chunk.Index, chunk.Total)
+			}
+			if len(chunk.Data) > MaxPayloadSize-chunkOverhead {
+				t.Errorf("%d bytes: chunk %d holds %d bytes", test.size, i, len(chunk.Data))
+			}
+			joined += chunk.Data
+		}
+
+		if joined != data {
+			t.Errorf("%d bytes: chunks join to %d bytes", test.size, len(joined))
+		}
+	}
+}
+
+func TestTrimAppUpdate(t *testing.T) {
+	defer func(size int) { MaxPayloadSize = size }(MaxPayloadSize)
+
+	// Seeded IDs don't compress, so every match adds to the encoded size
+	var matches []Match
+	for i := 0; i < 10; i++ {
+		name := GenerateSeededID(fmt.Sprintf("match-%d", i), "1528988400")
+		matches = append(matches, Match{Name: name, StartDate: "1528988400"})
+	}
+	message := AppUpdateMessage{Sequence: 3, Matches: matches}
+
+	tests := []struct {
+		keep    int
+		removed int
+	}{
+		{9, 1},
+		{5, 5},
+		{0, 10},
+	}
+
+	for _, test := range tests {
+		limitData, err := EncodeData(AppUpdateMessage{Sequence: 3, Matches: matches[:test.keep]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		MaxPayloadSize = len(limitData)
+
+		encodedData, removed, err := TrimAppUpdate(message)
+		if err != nil {
+			t.Errorf("keep %d: %v", test.keep, err)
+			continue
+		}
+		if removed != test.removed {
+			t.Errorf("keep %d: removed %d, expected %d", test.keep, removed, test.removed)
+		}
+
+		var trimmed AppUpdateMessage
+		decodeTestData(t, encodedData, &trimmed)
+		if len(trimmed.Matches) != test.keep || trimmed.Sequence != 3 {
+			t.Errorf("keep %d: kept %d matches at sequence %d", test.keep, len(trimmed.Matches), trimmed.Sequence)
+		}
+		for i := range trimmed.Matches {
+			if trimmed.Matches[i].Name != matches[i].Name {
+				t.Errorf("keep %d: match %d is %s, expected %s", test.keep, i, trimmed.Matches[i].Name, matches[i].Name)
+			}
+		}
+	}
+
+	// Nothing left to trim
+	MaxPayloadSize = 1
+	_, removed, err := TrimAppUpdate(message)
+	if err == nil || removed != len(matches) {
+		t.Errorf("removed %d with %v, expected every match and an error", removed, err)
+	}
+}
+
+func TestOversizedEvents(t *testing.T) {
+	defer func(size int, policy string) {
+		MaxPayloadSize = size
+		PayloadOversizePolicy = policy
+	}(MaxPayloadSize, PayloadOversizePolicy)
+
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	message := AppUpdateMessage{Matches: []Match{
+		{Name: GenerateSeededID("a", "1"), StartDate: "1"},
+		{Name: GenerateSeededID("b", "1"), StartDate: "1"},
+	}}
+	encodedData, err := EncodeData(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunks that hold a byte over half the payload split it in two
+	halfChunk := chunkOverhead + len(encodedData)/2 + 1
+
+	tests := []struct {
+		policy  string
+		data    interface{}
+		maxSize int
+		events  []string
+	}{
+		{PayloadTrim, message, len(encodedData) - 1, []string{AppUpdateEvent}},
+		{PayloadChunk, message, halfChunk, []string{AppUpdateEvent + "-chunk", AppUpdateEvent + "-chunk"}},
+		// Only app updates can be trimmed
+		{PayloadTrim, "other", halfChunk, []string{AppUpdateEvent + "-chunk", AppUpdateEvent + "-chunk"}},
+	}
+
+	for _, test := range tests {
+		PayloadOversizePolicy = test.policy
+		MaxPayloadSize = test.maxSize
+
+		events, err := svc.OversizedEvents(test.data, encodedData, "soccer", AppUpdateEvent)
+		if err != nil {
+			t.Errorf("%s: %v", test.policy, err)
+			continue
+		}
+
+		var names []string
+		for _, event := range events {
+			names = append(names, event.Event)
+		}
+		if strings.Join(names, ",") != strings.Join(test.events, ",") {
+			t.Errorf("%s %T: events %v, expected %v", test.policy, test.data, names, test.events)
+		}
+	}
+}
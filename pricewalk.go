@@ -0,0 +1,155 @@
+package service
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+
+	"github.com/a-h/round"
+)
+
+// Price walk parameters, applied to log odds once per recalculation tick
+var (
+	// Fraction of the gap to the provider consensus closed each tick
+	MeanReversion = 0.05
+	// Constant drift in log odds per tick
+	PriceDrift = 0.0
+	// Standard deviation of log odds per tick far from kickoff
+	BaseVolatility = 0.004
+	// Multiplier on volatility as the time scale approaches 1 at kickoff
+	KickoffVolatility = 4.0
+	// Chance of a price jump on each tick
+	JumpProbability = 0.002
+	// Standard deviation of log odds for a jump
+	JumpSize = 0.05
+)
+
+const (
+	MinOdds = 1.01
+	MaxOdds = 200
+)
+
+// PriceWalkStore keeps the last prices generated for each match, the walk carries on from these rather than
+// from the live book so resting user orders can't drag the price around
+type PriceWalkStore struct {
+	mutex sync.Mutex
+	walks map[string]BestOdds
+}
+
+func NewPriceWalkStore() *PriceWalkStore {
+	return &PriceWalkStore{
+		walks: make(map[string]BestOdds),
+	}
+}
+
+func (s *PriceWalkStore) Get(matchID string) (BestOdds, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	walk, ok := s.walks[matchID]
+	return walk, ok
+}
+
+func (s *PriceWalkStore) Set(matchID string, odds BestOdds) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.walks[matchID] = odds
+}
+
+// Prune drops the walks of any match that is no longer active
+func (s *PriceWalkStore) Prune(active map[string]Match) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for matchID := range s.walks {
+		if _, ok := active[matchID]; !ok {
+			delete(s.walks, matchID)
+		}
+	}
+}
+
+// walkStart returns the prices a match's walk carries on from, the last generated prices if there are any,
+// then the provider consensus, and only the book itself when neither is known, e.g. just after a restart
+func (svc *Service) walkStart(match Match) BestOdds {
+	if walk, ok := svc.Walks.Get(match.ID()); ok {
+		return walk
+	}
+
+	book := FindBestOdds(match)
+	if match.Consensus == nil || len(match.Consensus.Probabilities) < 1 {
+		return book
+	}
+
+	start := BestOdds{
+		Back: make([]float64, len(match.Consensus.Probabilities)),
+		Lay:  make([]float64, len(match.Consensus.Probabilities)),
+	}
+
+	for outcome, probability := range match.Consensus.Probabilities {
+		if probability <= 0 {
+			continue
+		}
+
+		start.Back[outcome] = 1 / probability
+		start.Lay[outcome] = start.Back[outcome] + math.Max(getOutcomeOdds(book.Lay, outcome)-getOutcomeOdds(book.Back, outcome), 0.01)
+	}
+
+	return start
+}
+
+// EvolveOdds moves a match's generated prices one step along a mean-reverting walk towards the provider consensus
+func (svc *Service) EvolveOdds(match Match) BestOdds {
+	current := svc.walkStart(match)
+
+	start, err := strconv.ParseInt(match.StartDate, 10, 64)
+	if err != nil {
+		return current
+	}
+
+	timeScale := GetTimeScale(start)
+	volatility := BaseVolatility * (1 + KickoffVolatility*math.Max(timeScale, 0))
+
+	evolved := BestOdds{
+		Back: make([]float64, len(current.Back)),
+		Lay:  make([]float64, len(current.Back)),
+	}
+
+	for outcome, back := range current.Back {
+		anchor := back
+		if match.Consensus != nil && outcome < len(match.Consensus.Probabilities) && match.Consensus.Probabilities[outcome] > 0 {
+			anchor = 1 / match.Consensus.Probabilities[outcome]
+		}
+
+		if back <= 0 {
+			back = anchor
+		}
+		if back <= 0 {
+			continue
+		}
+
+		// Keep the generated back/lay spread so the ladder moves as one
+		spread := getOutcomeOdds(current.Lay, outcome) - back
+		if spread < 0.01 {
+			spread = 0.01
+		}
+
+		x := math.Log(back)
+		x += MeanReversion*(math.Log(anchor)-x) + PriceDrift + volatility*rand.NormFloat64()
+		if isRandSuccess(JumpProbability) {
+			x += JumpSize * rand.NormFloat64()
+		}
+
+		newBack := clampOdds(math.Exp(x))
+
+		evolved.Back[outcome] = round.AwayFromZero(newBack, 2)
+		evolved.Lay[outcome] = round.AwayFromZero(clampOdds(newBack+spread), 2)
+	}
+
+	return evolved
+}
+
+func clampOdds(odds float64) float64 {
+	return math.Min(math.Max(odds, MinOdds), MaxOdds)
+}
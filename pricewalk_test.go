@@ -0,0 +1,61 @@
+package service
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEvolveOddsIgnoresBook(t *testing.T) {
+	defer func(volatility, jump float64) {
+		BaseVolatility, JumpProbability = volatility, jump
+	}(BaseVolatility, JumpProbability)
+	BaseVolatility, JumpProbability = 0, 0
+
+	svc := &Service{Walks: NewPriceWalkStore()}
+
+	// A resting user order has pushed the best back price well away from the generated price
+	match := Match{
+		Name:      "Home v Away",
+		StartDate: strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10),
+		Outcomes:  2,
+		MatchOdds: &MatchOdds{
+			Back: [][]Odds{{{Odds: 50}}, {{Odds: 1.9}}},
+			Lay:  [][]Odds{{{Odds: 51}}, {{Odds: 2}}},
+		},
+		Consensus: &Consensus{Probabilities: []float64{0.5, 0.5}},
+	}
+
+	tests := []struct {
+		name string
+		walk *BestOdds
+		back float64
+	}{
+		{
+			name: "carries on from the last generated price",
+			walk: &BestOdds{Back: []float64{2.2, 1.8}, Lay: []float64{2.3, 1.9}},
+			back: math.Exp(0.95*math.Log(2.2) + 0.05*math.Log(2)),
+		},
+		{
+			name: "starts from the consensus without a walk",
+			back: 2,
+		},
+	}
+
+	for _, test := range tests {
+		svc.Walks = NewPriceWalkStore()
+		if test.walk != nil {
+			svc.Walks.Set(match.ID(), *test.walk)
+		}
+
+		evolved := svc.EvolveOdds(match)
+		if len(evolved.Back) != 2 {
+			t.Fatalf("%s: evolved %d outcomes", test.name, len(evolved.Back))
+		}
+
+		if math.Abs(evolved.Back[0]-test.back) > 0.01 {
+			t.Errorf("%s: back = %f, expected %f", test.name, evolved.Back[0], test.back)
+		}
+	}
+}
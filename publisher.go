@@ -0,0 +1,146 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	pusher "github.com/pusher/pusher-http-go"
+)
+
+// Pusher accepts at most this many events in one batch trigger
+const PusherBatchLimit = 10
+
+// Publisher sends events to clients subscribed to a channel
+type Publisher interface {
+	Publish(channel, event string, data interface{}) error
+	PublishBatch(events []PublishEvent) error
+}
+
+type PublishEvent struct {
+	Channel string
+	Event   string
+	Data    interface{}
+	// Sequence of the channel message the event carries, zero when the channel isn't sequenced
+	Sequence int64
+}
+
+// PublishQueue runs pushes one after another in the order they were queued, so a slow push
+// can't be overtaken by the next tick's deltas on the same channel
+type PublishQueue struct {
+	mutex sync.Mutex
+	last  chan struct{}
+}
+
+func NewPublishQueue() *PublishQueue {
+	return &PublishQueue{}
+}
+
+// Go queues a push behind every push already queued and returns without waiting for it
+func (q *PublishQueue) Go(push func()) {
+	q.mutex.Lock()
+	previous := q.last
+	done := make(chan struct{})
+	q.last = done
+	q.mutex.Unlock()
+
+	go func() {
+		defer close(done)
+
+		if previous != nil {
+			<-previous
+		}
+		push()
+	}()
+}
+
+// PublishBackend is a publisher with its own breaker, so an outage on one backend doesn't hold up the others
+type PublishBackend struct {
+	Name      string
+	Publisher Publisher
+	Breaker   *CircuitBreaker
+}
+
+func NewPublishBackend(name string, publisher Publisher) *PublishBackend {
+	return &PublishBackend{
+		Name:      name,
+		Publisher: publisher,
+		Breaker:   &CircuitBreaker{},
+	}
+}
+
+// NewPublishers builds the publish backends from a comma separated list, pusher and hub,
+// events are published to every backend given
+func NewPublishers(backends string, pusherClient *pusher.Client, hub *Hub) ([]*PublishBackend, error) {
+	var publishers []*PublishBackend
+	for _, backend := range strings.Split(backends, ",") {
+		name := strings.TrimSpace(backend)
+		switch name {
+		case "pusher":
+			publishers = append(publishers, NewPublishBackend(name, &PusherPublisher{Client: pusherClient}))
+		case "hub":
+			publishers = append(publishers, NewPublishBackend(name, hub))
+		case "":
+		default:
+			return nil, fmt.Errorf("Unknown publisher: %s", backend)
+		}
+	}
+
+	if len(publishers) < 1 {
+		return nil, fmt.Errorf("No publishers configured")
+	}
+
+	return publishers, nil
+}
+
+// PusherPublisher publishes through a Pusher app
+type PusherPublisher struct {
+	Client *pusher.Client
+}
+
+func (p *PusherPublisher) Publish(channel, event string, data interface{}) error {
+	_, err := p.Client.Trigger(channel, event, data)
+	return err
+}
+
+// PublishBatch sends events in batches of PusherBatchLimit
+func (p *PusherPublisher) PublishBatch(events []PublishEvent) error {
+	for start := 0; start < len(events); start += PusherBatchLimit {
+		end := start + PusherBatchLimit
+		if end > len(events) {
+			end = len(events)
+		}
+
+		var batch []pusher.Event
+		for _, event := range events[start:end] {
+			data, err := pusherData(event.Data)
+			if err != nil {
+				return err
+			}
+
+			batch = append(batch, pusher.Event{
+				Channel: event.Channel,
+				Name:    event.Event,
+				Data:    data,
+			})
+		}
+
+		_, err := p.Client.TriggerBatch(batch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pusherData matches what Trigger sends, strings go as they are and anything else as JSON
+func pusherData(data interface{}) (string, error) {
+	if str, ok := data.(string); ok {
+		return str, nil
+	}
+
+	dataJSON, err := json.Marshal(data)
+	return string(dataJSON), err
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPublishQueueKeepsOrder(t *testing.T) {
+	queue := NewPublishQueue()
+
+	var mutex sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+
+	// Earlier pushes are slower, so they'd be overtaken if they ran side by side
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		push := i
+		queue.Go(func() {
+			defer wg.Done()
+			time.Sleep(time.Duration(5-push) * 5 * time.Millisecond)
+
+			mutex.Lock()
+			order = append(order, push)
+			mutex.Unlock()
+		})
+	}
+
+	wg.Wait()
+
+	for i, push := range order {
+		if push != i {
+			t.Fatalf("pushes ran in order %v", order)
+		}
+	}
+}
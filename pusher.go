@@ -7,9 +7,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"sync"
 )
 
 type AppUpdateMessage struct {
+	Sequence       int64               `json:"sequence"`
 	Currencies     map[string]Currency `json:"currencies"`
 	Matches        []Match             `json:"matches"`
 	BlockchainData BlockInfoResponse   `json:"blockchain_data"`
@@ -17,6 +19,22 @@ type AppUpdateMessage struct {
 
 const MaxResult = 25
 
+// Every client can subscribe to the block channel for height updates between app updates
+const (
+	BlockChannel     = "markets-blockchain"
+	BlockUpdateEvent = "block-update"
+)
+
+// Number of batch triggers sent at once when fanning out app updates
+var PushConcurrency = 4
+
+type PublishFailure struct {
+	Backend string
+	Channel string
+	Event   string
+	Err     error
+}
+
 func (svc *Service) PushAppUpdates() {
 	var sportMatches map[string][]Match
 	err := svc.GetRedis("sport-matches", &sportMatches)
@@ -32,117 +50,222 @@ func (svc *Service) PushAppUpdates() {
 		return
 	}
 
+	var events []PublishEvent
+
 	for sport, matches := range sportMatches {
-		go svc.PushUpdate(matches, sport)
+		events = append(events, svc.UpdateEvents(matches, sport)...)
 	}
 
 	for competition, matches := range competitionMatches {
 		sport := matches[0].Sport
 		channelString := sport + "-" + competition
 
-		go svc.PushUpdate(matches, channelString)
+		events = append(events, svc.UpdateEvents(matches, channelString)...)
 	}
 
-	go svc.PushFPUpdate(sportMatches)
+	events = append(events, svc.FPUpdateEvents(sportMatches)...)
+
+	blockEvents, err := svc.EncodeEvents(svc.BlockInfo(), BlockChannel, BlockUpdateEvent)
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+	}
+	events = append(events, blockEvents...)
+
+	// Sequences were assigned in this order, so the pushes have to go out in it too
+	svc.Publishes.Go(func() {
+		failures := svc.PublishEvents(events)
+		for _, failure := range failures {
+			svc.Logger.Log("error", fmt.Sprintf("Error pushing data %s to %s: %s", failure.Channel, failure.Backend, failure.Err.Error()))
+		}
+
+		svc.Deltas.Resolve(events, failures)
+	})
 
 	return
 }
 
-func (svc *Service) PushUpdate(matches []Match, channelString string) {
-	channelDate := "markets-" + channelString + "-date"
-	channelPopular := "markets-" + channelString + "-popular"
+// PublishEvents sends events to every backend side by side, each in batches with at most PushConcurrency
+// requests at once, a failed batch is retried event by event on that backend only
+func (svc *Service) PublishEvents(events []PublishEvent) []PublishFailure {
+	var wg sync.WaitGroup
+	var failureMutex sync.Mutex
+	var failures []PublishFailure
 
-	sort.Sort(ByDate(matches))
-	truncatedMatches := TruncateMatches(matches, MaxResult)
+	for _, backend := range svc.Publishers {
+		wg.Add(1)
+		go func(backend *PublishBackend) {
+			defer wg.Done()
+
+			backendFailures := svc.publishToBackend(backend, events)
+
+			failureMutex.Lock()
+			failures = append(failures, backendFailures...)
+			failureMutex.Unlock()
+		}(backend)
+	}
+
+	wg.Wait()
+
+	return failures
+}
+
+func (svc *Service) publishToBackend(backend *PublishBackend, events []PublishEvent) []PublishFailure {
+	var wg sync.WaitGroup
+	var failureMutex sync.Mutex
+	var failures []PublishFailure
+
+	semaphore := make(chan struct{}, PushConcurrency)
+
+	for start := 0; start < len(events); start += PusherBatchLimit {
+		end := start + PusherBatchLimit
+		if end > len(events) {
+			end = len(events)
+		}
+
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(batch []PublishEvent) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			if backend.Breaker.Allow() {
+				if backend.Publisher.PublishBatch(batch) == nil {
+					backend.Breaker.Success()
+					return
+				}
+				backend.Breaker.Failure()
+			}
+
+			for _, event := range batch {
+				err := svc.pushToBackend(backend, event)
+				if err != nil {
+					failureMutex.Lock()
+					failures = append(failures, PublishFailure{
+						Backend: backend.Name,
+						Channel: event.Channel,
+						Event:   event.Event,
+						Err:     err,
+					})
+					failureMutex.Unlock()
+				}
+			}
+		}(events[start:end])
+	}
 
-	blockchainInfo := BlockInfoResponse{
+	wg.Wait()
+
+	return failures
+}
+
+func (svc *Service) BlockInfo() BlockInfoResponse {
+	return BlockInfoResponse{
 		AverageBlockTime: svc.Internals.AverageTime,
 		BlockHeight:      svc.Internals.BlockHeight,
 		UpdatedAt:        svc.Internals.UpdatedAt.Unix(),
+		ChainStalled:     svc.Internals.ChainStalled,
 	}
+}
+
+// UpdateEvents builds the date and popular app updates for a sport or competition
+func (svc *Service) UpdateEvents(matches []Match, channelString string) (events []PublishEvent) {
+	channelDate := "markets-" + channelString + "-date"
+	channelPopular := "markets-" + channelString + "-popular"
+
+	sort.Sort(ByDate(matches))
+	truncatedMatches := TruncateMatches(matches, MaxResult)
 
 	messageData := AppUpdateMessage{
 		Matches:        truncatedMatches,
 		Currencies:     svc.Internals.PriceDetails.CurrencyData,
-		BlockchainData: blockchainInfo,
+		BlockchainData: svc.BlockInfo(),
 	}
 
-	err := svc.EncodeAndPush(messageData, channelDate, "app-update")
+	channelEvents, err := svc.AppUpdateEvents(channelDate, messageData)
 	if err != nil {
-		svc.Logger.Log("error", fmt.Sprintf("Error pushing data %s: %s", channelDate, err.Error()))
+		svc.Logger.Log("error", fmt.Sprintf("Error encoding data %s: %s", channelDate, err.Error()))
 		return
 	}
+	events = append(events, channelEvents...)
 
 	sort.Sort(ByPopular(matches))
 	truncatedMatches = TruncateMatches(matches, MaxResult)
 
 	messageData.Matches = truncatedMatches
-	err = svc.EncodeAndPush(messageData, channelPopular, "app-update")
+	channelEvents, err = svc.AppUpdateEvents(channelPopular, messageData)
 	if err != nil {
-		svc.Logger.Log("error", fmt.Sprintf("Error pushing data %s: %s", channelPopular, err.Error()))
+		svc.Logger.Log("error", fmt.Sprintf("Error encoding data %s: %s", channelPopular, err.Error()))
 		return
 	}
+	events = append(events, channelEvents...)
 
+	return
 }
 
-func (svc *Service) PushFPUpdate(matchMap map[string][]Match) {
+// FPUpdateEvents builds the front page date and popular app updates
+func (svc *Service) FPUpdateEvents(matchMap map[string][]Match) (events []PublishEvent) {
 	channelDate := "markets-date"
 	channelPopular := "markets-popular"
 
 	matches := GetFPMatches(matchMap, svc.Internals.SportKeys, "date")
 
-	blockchainInfo := BlockInfoResponse{
-		AverageBlockTime: svc.Internals.AverageTime,
-		BlockHeight:      svc.Internals.BlockHeight,
-		UpdatedAt:        svc.Internals.UpdatedAt.Unix(),
-	}
-
 	messageData := AppUpdateMessage{
 		Matches:        matches,
 		Currencies:     svc.Internals.PriceDetails.CurrencyData,
-		BlockchainData: blockchainInfo,
+		BlockchainData: svc.BlockInfo(),
 	}
 
-	err := svc.EncodeAndPush(messageData, channelDate, "app-update")
+	channelEvents, err := svc.AppUpdateEvents(channelDate, messageData)
 	if err != nil {
-		svc.Logger.Log("error", fmt.Sprintf("Error pushing data %s: %s", channelDate, err.Error()))
+		svc.Logger.Log("error", fmt.Sprintf("Error encoding data %s: %s", channelDate, err.Error()))
 		return
 	}
+	events = append(events, channelEvents...)
 
 	matches = GetFPMatches(matchMap, svc.Internals.SportKeys, "popular")
 
 	messageData.Matches = matches
-	err = svc.EncodeAndPush(messageData, channelPopular, "app-update")
+	channelEvents, err = svc.AppUpdateEvents(channelPopular, messageData)
 	if err != nil {
-		svc.Logger.Log("error", fmt.Sprintf("Error pushing data %s: %s", channelPopular, err.Error()))
+		svc.Logger.Log("error", fmt.Sprintf("Error encoding data %s: %s", channelPopular, err.Error()))
 		return
 	}
+	events = append(events, channelEvents...)
 
 	return
 }
 
-func (svc *Service) EncodeAndPush(messageData AppUpdateMessage, channelName, eventName string) (err error) {
-	encodedData, err := EncodeData(messageData)
+func (svc *Service) EncodeAndPush(messageData interface{}, channelName, eventName string) (err error) {
+	events, err := svc.EncodeEvents(messageData, channelName, eventName)
 	if err != nil {
 		return
 	}
 
-	count := 0
-
-	// Retry up to 5 times if it fails, otherwise break
-	for count < 5 {
-		_, err = svc.PusherClient.Trigger(channelName, eventName, encodedData)
+	for _, event := range events {
+		err = svc.PushWithRetry(event)
 		if err != nil {
-			count++
-			continue
+			return
 		}
-
-		break
 	}
 
 	return
 }
 
+// EncodeEvents encodes a message into the events to publish, splitting or trimming it if it's oversized
+func (svc *Service) EncodeEvents(messageData interface{}, channelName, eventName string) ([]PublishEvent, error) {
+	encodedData, err := EncodeData(messageData)
+	if err != nil {
+		return nil, err
+	}
+
+	svc.RecordPayloadSize(channelName, len(encodedData))
+
+	if len(encodedData) > MaxPayloadSize {
+		return svc.OversizedEvents(messageData, encodedData, channelName, eventName)
+	}
+
+	return []PublishEvent{{Channel: channelName, Event: eventName, Data: encodedData}}, nil
+}
+
 func EncodeData(data interface{}) (string, error) {
 	var buf bytes.Buffer
 	zipper := zlib.NewWriter(&buf)
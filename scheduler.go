@@ -12,13 +12,15 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/go-redis/redis"
 	"github.com/parnurzeal/gorequest"
 	"github.com/robfig/cron"
 )
 
 var APIToken = os.Getenv("SPORTS_API_TOKEN")
 
-var OddsSources = []string{"bet365", "betfair", "10bet", "williamhill", "betclic", "ysb88", "bwin", "betfred", "betsson", "sbobet", "marathonbet", "intertops", "interwetten", "1xbet", "skybet", "marsbet"}
+// Providers whose prices are used, sharpest first
+var OddsSources = sourceNames(ProviderSharpness)
 
 // Time to wait for a block update until we reselect the best node
 var NodeResetTime = int64(60)
@@ -33,9 +35,12 @@ func (svc *Service) InitialiseScheduler() {
 	c := cron.New()
 
 	c.AddFunc("@every 1s", svc.FetchBlockchainData)
+	c.AddFunc("@every 30s", svc.ProbeNodes)
+	c.AddFunc("@every 5s", svc.ScanContractBlocks)
 	c.AddFunc("@every 5s", svc.FetchPriceData)
 	c.AddFunc("@every 10s", svc.RecalculateMatchData)
 	c.AddFunc("@every 15m", svc.FetchEventData)
+	c.AddFunc("@every 1m", svc.GenerateArbitrageReport)
 
 	svc.FetchPriceData()
 	svc.FetchEventData()
@@ -51,26 +56,28 @@ func (svc *Service) FetchBlockchainData() {
 
 	svc.Internals.DebugCount++ // Debug count if chain does not update
 
-	newHeight, err := svc.NeoClient.GetBlockCount()
+	newHeight, err := svc.Chain.GetBlockCount()
 	if err != nil {
 		svc.Logger.Log("error", fmt.Sprintf("Unable to fetch block height: %v", err))
-		svc.NeoClient.SelectBestNode() // Reselect best node
 	}
 
 	if newHeight > svc.Internals.BlockHeight {
 		err = svc.UpdateBlockHeight(newHeight)
 		if err != nil {
 			svc.Logger.Log("error", err.Error())
+			mutex.Unlock()
 			return
 		}
 
 		svc.PushAppUpdates()
 		svc.Internals.DebugCount = 0
 	} else if svc.Internals.DebugCount > NodeResetTime {
-		svc.NeoClient.SelectBestNode() // Reselect best node
+		svc.ProbeNodes() // Reselect best node
 		svc.Internals.DebugCount = 0
 	}
 
+	svc.CheckChainStall()
+
 	mutex.Unlock()
 }
 
@@ -117,6 +124,7 @@ func (svc *Service) FetchEventData() {
 
 	competitionOverview := make(map[string]*CompetitionInfo)
 	competitionMatched := make(map[string]float64)
+	providerOdds := make(map[string][]ProviderOdd)
 
 	// Open and read whitelist file
 	file, err := os.Open("api_whitelist.csv")
@@ -127,6 +135,18 @@ func (svc *Service) FetchEventData() {
 
 	csvR := csv.NewReader(file)
 
+	// Previous matches let refreshed prices carry on from where the walk left off
+	var previousMatchList []Match
+	err = svc.GetRedis("all-matches", &previousMatchList)
+	if err != nil && err != redis.Nil {
+		svc.Logger.Log("error", err.Error())
+	}
+
+	previousMatches := make(map[string]Match)
+	for _, match := range previousMatchList {
+		previousMatches[match.ID()] = match
+	}
+
 	svc.Logger.Log("msg", "Fetching match data")
 
 	// Iterate over each row
@@ -172,10 +192,12 @@ func (svc *Service) FetchEventData() {
 				return
 			}
 
+			svc.MarkLeagueUpdated(leagueInternalID)
+
 			for _, event := range response.Results {
 
 				var hasDraw = false
-				var odds []ThreeWayOdd
+				var odds []ProviderOdd
 
 				var oddsResponse EventOddsResponseA
 				_, _, errs := gorequest.New().
@@ -190,10 +212,13 @@ func (svc *Service) FetchEventData() {
 				}
 
 				oddsReflect := reflect.ValueOf(oddsResponse.Results)
+				providerTypes := oddsReflect.Type()
 
 				// Iterate over all the returned providers
 				// Have to re-marshal to find poorly returned objects and to cast to Provider struct
 				for i := 0; i < oddsReflect.NumField(); i++ {
+					providerName := strings.ToLower(providerTypes.Field(i).Tag.Get("json"))
+
 					rawProvider, ok := oddsReflect.Field(i).Interface().(interface{})
 					if !ok {
 						// expected when nil interface is given
@@ -220,7 +245,10 @@ func (svc *Service) FetchEventData() {
 						continue
 					}
 
-					odds = append(odds, latestOdds)
+					odds = append(odds, ProviderOdd{
+						Provider: providerName,
+						Odds:     latestOdds,
+					})
 					if latestOdds.DrawOdds != "" {
 						hasDraw = true
 					}
@@ -254,13 +282,30 @@ func (svc *Service) FetchEventData() {
 					Scale:           scale,
 				}
 
-				bestOdds := svc.GetBestOdds(match, odds)
+				bestOdds := svc.GetBestOdds(&match, odds)
+
+				// Large consensus moves suspend the market, its book restarts at the new price once it reopens
+				previous := previousMatches[match.ID()]
+				moved := CheckConsensusMove(previous, &match)
+				if previous.MatchOdds != nil && !moved {
+					match.MatchOdds = previous.MatchOdds
+					match.Matched = previous.Matched
+					match.SimulatedMatched = previous.SimulatedMatched
+					bestOdds = svc.EvolveOdds(match)
+				}
+
 				_ = svc.UpdateMatchData(bestOdds, &match)
 
+				err := svc.RecordOddsHistory(previous, match)
+				if err != nil {
+					svc.Logger.Log("error", err.Error())
+				}
+
 				// Add match to appropriate maps/lists
 				eventMutex.Lock()
 
 				matches = append(matches, match)
+				providerOdds[match.ID()] = odds
 				sportMatches[sportID] = append(sportMatches[sportID], match)
 				competitionMatches[competitionID] = append(competitionMatches[competitionID], match)
 
@@ -377,6 +422,23 @@ func (svc *Service) FetchEventData() {
 		return
 	}
 
+	sportMatched := make(map[string]float64)
+	for sport, matches := range sportMatches {
+		sportMatched[sport] = RollUpMatched(matches)
+	}
+
+	err = svc.SetRedis("sport-amounts", &sportMatched)
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		return
+	}
+
+	err = svc.SetRedis("provider-odds", &providerOdds)
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		return
+	}
+
 	err = svc.SetRedis("navigation", &navigation)
 	if err != nil {
 		svc.Logger.Log("error", err.Error())
@@ -429,12 +491,46 @@ func (svc *Service) RecalculateMatchData() {
 		return
 	}
 
+	// Update each match once so every list shares the same book and ledger volume
+	updatedMatches := make(map[string]Match)
+	for key, match := range allMatches {
+		previous := match
+		bestOdds := svc.EvolveOdds(match)
+		svc.UpdateMatchData(bestOdds, &match)
+		allMatches[key] = match
+		updatedMatches[match.ID()] = match
+
+		err = svc.RecordOddsHistory(previous, match)
+		if err != nil {
+			svc.Logger.Log("error", err.Error())
+		}
+	}
+
+	svc.OrderBooks.Prune(updatedMatches)
+	svc.Walks.Prune(updatedMatches)
+	svc.PruneMarketHashes(updatedMatches)
+
+	err = svc.PruneMatchedLedger(updatedMatches)
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+	}
+
+	err = svc.StoreMatchRecords(updatedMatches)
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+	}
+
+	var marketMatches []Match
+	for _, match := range updatedMatches {
+		marketMatches = append(marketMatches, match)
+	}
+	go svc.PushMarketUpdates(marketMatches)
+
 	for competition, matches := range competitionMatches {
 		if len(matches) < 1 {
-			return
+			continue
 		}
 
-		totalMatched := float64(0)
 		firstDate := 9999999999
 
 		compIDRaw := matches[0].CompetitionID
@@ -444,7 +540,6 @@ func (svc *Service) RecalculateMatchData() {
 		compSport := strings.Replace(strings.ToLower(compSportRaw), " ", "-", -1)
 
 		for key, match := range matches {
-			totalMatched += match.Matched
 			date, err := strconv.Atoi(match.StartDate)
 			if err != nil {
 				svc.Logger.Log("error", fmt.Sprintf("Unable to parse start date into int %s: %s", competition, match.StartDate))
@@ -455,11 +550,13 @@ func (svc *Service) RecalculateMatchData() {
 				firstDate = date
 			}
 
-			bestOdds := FindBestOdds(match)
-			svc.UpdateMatchData(bestOdds, &match)
-			matches[key] = match
+			if updated, ok := updatedMatches[match.ID()]; ok {
+				matches[key] = updated
+			}
 		}
 
+		totalMatched := RollUpMatched(matches)
+
 		compInfo := CompetitionInfo{
 			ID:           compID,
 			Name:         compName,
@@ -473,20 +570,16 @@ func (svc *Service) RecalculateMatchData() {
 		competitionMatches[competition] = matches
 	}
 
-	for key, match := range allMatches {
-		bestOdds := FindBestOdds(match)
-		svc.UpdateMatchData(bestOdds, &match)
-		allMatches[key] = match
-	}
-
+	sportAmounts := make(map[string]float64)
 	for sport, matches := range sportMatches {
 		for key, match := range matches {
-			bestOdds := FindBestOdds(match)
-			svc.UpdateMatchData(bestOdds, &match)
-			matches[key] = match
+			if updated, ok := updatedMatches[match.ID()]; ok {
+				matches[key] = updated
+			}
 		}
 
 		sportMatches[sport] = matches
+		sportAmounts[sport] = RollUpMatched(matches)
 	}
 
 	err = svc.SetRedis("all-matches", &allMatches)
@@ -519,6 +612,12 @@ func (svc *Service) RecalculateMatchData() {
 		return
 	}
 
+	err = svc.SetRedis("sport-amounts", &sportAmounts)
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		return
+	}
+
 }
 
 func GetCurrencyRequest(response *map[string]Currency) error {
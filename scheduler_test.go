@@ -0,0 +1,120 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFetchBlockchainData(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	chain := NewMockChain(10, 15*time.Second)
+	svc.Chain = chain
+
+	tests := []struct {
+		name   string
+		setup  func()
+		height int64
+	}{
+		{"first poll", func() {}, 10},
+		{"new blocks", func() { chain.Advance(2) }, 12},
+		{"node error", func() { chain.Advance(1); chain.Fail(errors.New("node down"), 1) }, 12},
+		{"recovered", func() {}, 13},
+		{"stalled", func() { chain.Stall(true); chain.Advance(5) }, 13},
+	}
+
+	for _, test := range tests {
+		test.setup()
+		svc.FetchBlockchainData()
+
+		if svc.Internals.BlockHeight != test.height {
+			t.Errorf("%s: height %d, expected %d", test.name, svc.Internals.BlockHeight, test.height)
+		}
+
+		var data BlockchainData
+		err := svc.GetRedis("blockchain_data", &data)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if data.BlockHeight != test.height || data.AverageBlockTime != 15 {
+			t.Errorf("%s: stored height %d at %fs a block, expected %d at 15s", test.name, data.BlockHeight, data.AverageBlockTime, test.height)
+		}
+	}
+}
+
+func TestCheckChainStall(t *testing.T) {
+	defer func(webhook string) { ChainAlertWebhook = webhook }(ChainAlertWebhook)
+	ChainAlertWebhook = ""
+
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	chain := NewMockChain(10, 15*time.Second)
+	svc.Chain = chain
+	svc.FetchBlockchainData()
+
+	// A recent block isn't a stall
+	svc.CheckChainStall()
+	if svc.Internals.ChainStalled {
+		t.Fatal("stalled straight after a new block")
+	}
+
+	chain.Stall(true)
+	svc.Internals.UpdatedAt = time.Now().Add(-ChainStallTime - time.Second)
+	svc.FetchBlockchainData()
+	if !svc.Internals.ChainStalled {
+		t.Fatal("no stall raised once blocks stopped")
+	}
+
+	chain.Stall(false)
+	chain.Advance(1)
+	svc.FetchBlockchainData()
+	if svc.Internals.ChainStalled {
+		t.Fatal("still stalled after a new block")
+	}
+
+	rawAlerts, err := svc.RedisClient.LRange("chain-alerts", 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Alerts are pushed to the front, newest first
+	expected := []string{ChainRecoveredAlert, ChainStalledAlert}
+	if len(rawAlerts) != len(expected) {
+		t.Fatalf("%d alerts, expected %d", len(rawAlerts), len(expected))
+	}
+	for i, rawAlert := range rawAlerts {
+		var alert ChainAlert
+		err = json.Unmarshal([]byte(rawAlert), &alert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alert.Type != expected[i] {
+			t.Errorf("alert %d is %s, expected %s", i, alert.Type, expected[i])
+		}
+	}
+}
+
+func TestChainState(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	svc.Chain = NewMockChain(7, 15*time.Second)
+
+	state := svc.Chain.State()
+	if state.Backend != "mock" || state.Current != "mock" || state.Height != 7 {
+		t.Errorf("state = %+v", state)
+	}
+
+	if _, err := svc.RPC(); err != nil {
+		t.Errorf("mock chain has no RPC: %v", err)
+	}
+
+	svc.Chain = NewNodePool(nil)
+	if state := svc.Chain.State(); state.Backend != "neo" || len(state.Nodes) != 0 {
+		t.Errorf("empty pool state = %+v", state)
+	}
+}
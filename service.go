@@ -2,12 +2,13 @@ package service
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/robfig/cron"
 
-	"github.com/CityOfZion/neo-go-sdk/neo"
 	"github.com/go-kit/kit/log"
 	"github.com/go-redis/redis"
 	pusher "github.com/pusher/pusher-http-go"
@@ -17,38 +18,53 @@ type Service struct {
 	Logger       log.Logger
 	RedisClient  *redis.Client
 	PusherClient *pusher.Client
-	NeoClient    *neo.Client
+	Publishers   []*PublishBackend
+	Hub          *Hub
+	Chain        ChainClient
 	Internals    InternalDetails
 	Cron         *cron.Cron
+	OrderBooks   *OrderBookStore
+	Walks        *PriceWalkStore
+	Deltas       *DeltaStore
+	Publishes    *PublishQueue
+	Markets      *MarketStore
 }
 
 type InternalDetails struct {
-	UpdatedAt     time.Time
-	BlockHeight   int64
-	BlocksCounted int64
-	DebugCount    int64
-	AverageTime   float64
-	TimeCounted   float64
-	PriceDetails  PriceData
-	SportKeys     []SportKey
-	LeagueScales  map[string]float64
+	UpdatedAt    time.Time
+	BlockHeight  int64
+	DebugCount   int64
+	AverageTime  float64
+	ChainStalled bool
+	BlockTimes   BlockTimeWindow
+	PriceDetails PriceData
+	SportKeys    []SportKey
+	LeagueScales map[string]float64
+	// Last successful feed update for each league
+	LeagueUpdatedAt map[string]time.Time
 }
 
 // NewService prepares a new scheduler service
-func NewService(logger log.Logger, redisClient *redis.Client, pusherClient *pusher.Client, neoClient *neo.Client) *Service {
+func NewService(logger log.Logger, redisClient *redis.Client, pusherClient *pusher.Client, publishers []*PublishBackend, hub *Hub, chain ChainClient) *Service {
 	leagueScales := make(map[string]float64)
 
 	service := &Service{
 		Logger:       logger,
 		RedisClient:  redisClient,
 		PusherClient: pusherClient,
-		NeoClient:    neoClient,
+		Publishers:   publishers,
+		Hub:          hub,
+		Chain:        chain,
+		OrderBooks:   NewOrderBookStore(),
+		Walks:        NewPriceWalkStore(),
+		Deltas:       NewDeltaStore(),
+		Publishes:    NewPublishQueue(),
+		Markets:      NewMarketStore(),
 		Internals: InternalDetails{
-			BlockHeight:   0,
-			TimeCounted:   0,
-			BlocksCounted: 1,
-			UpdatedAt:     time.Now(),
-			LeagueScales:  leagueScales,
+			BlockHeight:     0,
+			UpdatedAt:       time.Now(),
+			LeagueScales:    leagueScales,
+			LeagueUpdatedAt: make(map[string]time.Time),
 		},
 	}
 
@@ -61,6 +77,7 @@ type BlockchainData struct {
 	BlockHeight      int64   `json:"block_height"`
 	AverageBlockTime float64 `json:"average_time"`
 	UpdatedAt        int64   `json:"updated_at"`
+	ChainStalled     bool    `json:"chain-stalled"`
 }
 
 type PriceData struct {
@@ -70,22 +87,49 @@ type PriceData struct {
 
 type Currency map[string]float64
 
+// CurrentBlockHeight reads the block height from outside the scheduler, it's written under the scheduler mutex
+// by FetchBlockchainData so callers holding that mutex read svc.Internals.BlockHeight directly
+func (svc *Service) CurrentBlockHeight() int64 {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return svc.Internals.BlockHeight
+}
+
 func (svc *Service) UpdateBlockHeight(height int64) error {
 	now := time.Now()
 
-	timeDifference := now.Sub(svc.Internals.UpdatedAt).Seconds()
-	averageTime := svc.Internals.TimeCounted / float64(svc.Internals.BlocksCounted)
+	// Average block time comes from the block timestamps, keeping the last average if they can't be read
+	err := svc.RecordBlockTimes(svc.Internals.BlockHeight, height)
+	if err != nil {
+		svc.Logger.Log("error", fmt.Sprintf("Unable to read block times: %v", err))
+	}
+
+	averageTime := svc.Internals.AverageTime
+	if blockAverage := svc.Internals.BlockTimes.Average(); blockAverage > 0 {
+		averageTime = blockAverage
+	}
 
-	svc.Internals.TimeCounted += timeDifference
 	svc.Internals.BlockHeight = height
+
+	if svc.Internals.ChainStalled {
+		svc.Internals.ChainStalled = false
+		svc.RaiseChainAlert(ChainRecoveredAlert)
+	}
+
 	svc.Internals.UpdatedAt = now
 	svc.Internals.AverageTime = averageTime
-	svc.Internals.BlocksCounted++
 
+	return svc.SetBlockchainData()
+}
+
+// SetBlockchainData stores the current chain state for the API
+func (svc *Service) SetBlockchainData() error {
 	data := BlockchainData{
-		BlockHeight:      height,
-		AverageBlockTime: averageTime,
-		UpdatedAt:        now.Unix(),
+		BlockHeight:      svc.Internals.BlockHeight,
+		AverageBlockTime: svc.Internals.AverageTime,
+		UpdatedAt:        svc.Internals.UpdatedAt.Unix(),
+		ChainStalled:     svc.Internals.ChainStalled,
 	}
 
 	return svc.SetRedis("blockchain_data", &data)
@@ -110,6 +154,73 @@ func (svc *Service) GetRedis(key string, v interface{}) (err error) {
 	return
 }
 
+// GetMatch finds a match by its seeded ID in the stored match list
+func (svc *Service) GetMatch(matchID string) (match Match, err error) {
+	var allMatches []Match
+	err = svc.GetRedis("all-matches", &allMatches)
+	if err != nil {
+		return
+	}
+
+	for _, match := range allMatches {
+		if match.ID() == matchID {
+			return match, nil
+		}
+	}
+
+	return match, fmt.Errorf("Match not found: %s", matchID)
+}
+
+func MatchRecordKey(matchID string) string {
+	return "match-record-" + matchID
+}
+
+// MatchRecordsKey indexes the stored match records by when they expire
+const MatchRecordsKey = "match-records"
+
+// StoreMatchRecords keeps each match under its own key until its history expires, so it can still be found
+// for settlement once it has started and dropped out of the listings
+func (svc *Service) StoreMatchRecords(matches map[string]Match) error {
+	pipe := svc.RedisClient.Pipeline()
+	queued := 0
+
+	for matchID, match := range matches {
+		expiresAt := HistoryExpiresAt(match)
+		expiry := time.Until(expiresAt)
+		if expiry <= 0 {
+			continue
+		}
+
+		matchJSON, err := json.Marshal(match)
+		if err != nil {
+			return err
+		}
+
+		pipe.Set(MatchRecordKey(matchID), matchJSON, expiry)
+		pipe.ZAdd(MatchRecordsKey, redis.Z{Score: float64(expiresAt.Unix()), Member: matchID})
+		queued++
+	}
+
+	if queued < 1 {
+		return nil
+	}
+
+	pipe.ZRemRangeByScore(MatchRecordsKey, "-inf", strconv.FormatInt(time.Now().Unix(), 10))
+
+	_, err := pipe.Exec()
+	return err
+}
+
+// GetMatchRecord finds a match by its seeded ID whether or not it is still listed
+func (svc *Service) GetMatchRecord(matchID string) (match Match, err error) {
+	err = svc.GetRedis(MatchRecordKey(matchID), &match)
+	if err == redis.Nil {
+		err = fmt.Errorf("Match not found: %s", matchID)
+	}
+
+	return
+}
+
 func (svc *Service) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode("OK")
 }
@@ -0,0 +1,1013 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-redis/redis"
+)
+
+// testRedis is an in-memory server speaking enough of the redis protocol for the service's commands
+type testRedis struct {
+	mutex    sync.Mutex
+	listener net.Listener
+	client   *redis.Client
+	data     map[string]interface{}
+	expires  map[string]time.Time
+	versions map[string]int
+}
+
+type zmember struct {
+	member string
+	score  float64
+}
+
+type testRedisConn struct {
+	watched map[string]int
+	queued  [][]string
+	multi   bool
+}
+
+type redisError string
+
+type redisStatus string
+
+func newTestRedis(t *testing.T) *testRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &testRedis{
+		listener: listener,
+		data:     make(map[string]interface{}),
+		expires:  make(map[string]time.Time),
+		versions: make(map[string]int),
+	}
+
+	go server.serve()
+	return server
+}
+
+// newTestService builds a service backed by an in-memory redis without starting the scheduler,
+// callers close the returned server when they are done
+func newTestService(t *testing.T) (*Service, *testRedis) {
+	server := newTestRedis(t)
+	server.client = redis.NewClient(&redis.Options{Addr: server.listener.Addr().String()})
+
+	svc := &Service{
+		Logger:      log.NewNopLogger(),
+		RedisClient: server.client,
+		OrderBooks:  NewOrderBookStore(),
+		Walks:       NewPriceWalkStore(),
+		Deltas:      NewDeltaStore(),
+		Publishes:   NewPublishQueue(),
+		Markets:     NewMarketStore(),
+		Internals: InternalDetails{
+			UpdatedAt:       time.Now(),
+			LeagueScales:    make(map[string]float64),
+			LeagueUpdatedAt: make(map[string]time.Time),
+		},
+	}
+
+	return svc, server
+}
+
+func (s *testRedis) Close() {
+	if s.client != nil {
+		s.client.Close()
+	}
+	s.listener.Close()
+}
+
+func (s *testRedis) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *testRedis) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	state := &testRedisConn{}
+
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		writeReply(writer, s.dispatch(state, args))
+
+		if reader.Buffered() == 0 {
+			writer.Flush()
+		}
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) < 1 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+
+	count, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, count)
+	for i := range args {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimRight(header, "\r\n")[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		buffer := make([]byte, size+2)
+		_, err = io.ReadFull(reader, buffer)
+		if err != nil {
+			return nil, err
+		}
+
+		args[i] = string(buffer[:size])
+	}
+
+	return args, nil
+}
+
+func writeReply(writer *bufio.Writer, reply interface{}) {
+	switch value := reply.(type) {
+	case nil:
+		writer.WriteString("$-1\r\n")
+	case redisStatus:
+		fmt.Fprintf(writer, "+%s\r\n", value)
+	case redisError:
+		fmt.Fprintf(writer, "-%s\r\n", value)
+	case int:
+		fmt.Fprintf(writer, ":%d\r\n", value)
+	case string:
+		fmt.Fprintf(writer, "$%d\r\n%s\r\n", len(value), value)
+	case []string:
+		fmt.Fprintf(writer, "*%d\r\n", len(value))
+		for _, item := range value {
+			fmt.Fprintf(writer, "$%d\r\n%s\r\n", len(item), item)
+		}
+	case []interface{}:
+		if value == nil {
+			writer.WriteString("*-1\r\n")
+			return
+		}
+
+		fmt.Fprintf(writer, "*%d\r\n", len(value))
+		for _, item := range value {
+			writeReply(writer, item)
+		}
+	}
+}
+
+func (s *testRedis) dispatch(state *testRedisConn, args []string) interface{} {
+	if len(args) < 1 {
+		return redisError("ERR empty command")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	name := strings.ToLower(args[0])
+	switch name {
+	case "multi":
+		state.multi = true
+		state.queued = nil
+		return redisStatus("OK")
+	case "discard":
+		state.multi = false
+		state.queued = nil
+		state.watched = nil
+		return redisStatus("OK")
+	case "watch":
+		if state.watched == nil {
+			state.watched = make(map[string]int)
+		}
+		for _, key := range args[1:] {
+			s.expire(key)
+			state.watched[key] = s.versions[key]
+		}
+		return redisStatus("OK")
+	case "unwatch":
+		state.watched = nil
+		return redisStatus("OK")
+	case "exec":
+		queued := state.queued
+		watched := state.watched
+		state.multi = false
+		state.queued = nil
+		state.watched = nil
+
+		for key, version := range watched {
+			s.expire(key)
+			if s.versions[key] != version {
+				return []interface{}(nil)
+			}
+		}
+
+		replies := make([]interface{}, 0, len(queued))
+		for _, queuedArgs := range queued {
+			replies = append(replies, s.run(strings.ToLower(queuedArgs[0]), queuedArgs[1:]))
+		}
+		return replies
+	}
+
+	if state.multi {
+		state.queued = append(state.queued, args)
+		return redisStatus("QUEUED")
+	}
+
+	return s.run(name, args[1:])
+}
+
+// Do runs a command directly against the stored data, for setting up and inspecting tests
+func (s *testRedis) Do(args ...string) interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.run(strings.ToLower(args[0]), args[1:])
+}
+
+// Keys returns every live key matching a glob pattern
+func (s *testRedis) Keys(pattern string) []string {
+	reply, _ := s.Do("keys", pattern).([]string)
+	return reply
+}
+
+func (s *testRedis) expire(key string) {
+	if at, ok := s.expires[key]; ok && !time.Now().Before(at) {
+		s.remove(key)
+	}
+}
+
+func (s *testRedis) remove(key string) bool {
+	_, ok := s.data[key]
+	delete(s.data, key)
+	delete(s.expires, key)
+	if ok {
+		s.versions[key]++
+	}
+	return ok
+}
+
+func (s *testRedis) touch(key string) {
+	s.versions[key]++
+}
+
+func (s *testRedis) hash(key string, create bool) (map[string]string, error) {
+	s.expire(key)
+	value, ok := s.data[key]
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		hash := make(map[string]string)
+		s.data[key] = hash
+		return hash, nil
+	}
+
+	hash, ok := value.(map[string]string)
+	if !ok {
+		return nil, errors.New("WRONGTYPE")
+	}
+	return hash, nil
+}
+
+func (s *testRedis) set(key string, create bool) (map[string]bool, error) {
+	s.expire(key)
+	value, ok := s.data[key]
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		set := make(map[string]bool)
+		s.data[key] = set
+		return set, nil
+	}
+
+	set, ok := value.(map[string]bool)
+	if !ok {
+		return nil, errors.New("WRONGTYPE")
+	}
+	return set, nil
+}
+
+func (s *testRedis) list(key string) ([]string, error) {
+	s.expire(key)
+	value, ok := s.data[key]
+	if !ok {
+		return nil, nil
+	}
+
+	list, ok := value.([]string)
+	if !ok {
+		return nil, errors.New("WRONGTYPE")
+	}
+	return list, nil
+}
+
+func (s *testRedis) zset(key string) ([]zmember, error) {
+	s.expire(key)
+	value, ok := s.data[key]
+	if !ok {
+		return nil, nil
+	}
+
+	zset, ok := value.([]zmember)
+	if !ok {
+		return nil, errors.New("WRONGTYPE")
+	}
+	return zset, nil
+}
+
+func (s *testRedis) storeList(key string, list []string) {
+	if len(list) < 1 {
+		s.remove(key)
+		return
+	}
+	s.data[key] = list
+	s.touch(key)
+}
+
+func (s *testRedis) storeZSet(key string, zset []zmember) {
+	if len(zset) < 1 {
+		s.remove(key)
+		return
+	}
+	sort.SliceStable(zset, func(i, j int) bool {
+		if zset[i].score != zset[j].score {
+			return zset[i].score < zset[j].score
+		}
+		return zset[i].member < zset[j].member
+	})
+	s.data[key] = zset
+	s.touch(key)
+}
+
+// rangeIndexes converts redis start and stop indexes, which may be negative, into slice bounds
+func rangeIndexes(startArg, stopArg string, length int) (int, int) {
+	start, _ := strconv.Atoi(startArg)
+	stop, _ := strconv.Atoi(stopArg)
+
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+
+	return start, stop + 1
+}
+
+func parseScoreBound(arg string) (float64, bool) {
+	exclusive := strings.HasPrefix(arg, "(")
+	arg = strings.TrimPrefix(arg, "(")
+
+	switch arg {
+	case "-inf":
+		return math.Inf(-1), exclusive
+	case "+inf", "inf":
+		return math.Inf(1), exclusive
+	}
+
+	value, _ := strconv.ParseFloat(arg, 64)
+	return value, exclusive
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func (s *testRedis) run(name string, args []string) interface{} {
+	for _, key := range commandKeys(name, args) {
+		s.expire(key)
+	}
+
+	switch name {
+	case "ping":
+		return redisStatus("PONG")
+	case "flushdb", "flushall":
+		for key := range s.data {
+			s.remove(key)
+		}
+		return redisStatus("OK")
+	case "get":
+		value, ok := s.data[args[0]]
+		if !ok {
+			return nil
+		}
+		str, ok := value.(string)
+		if !ok {
+			return redisError("WRONGTYPE")
+		}
+		return str
+	case "set":
+		var expiry time.Duration
+		nx, xx := false, false
+		for i := 2; i < len(args); i++ {
+			switch strings.ToLower(args[i]) {
+			case "ex":
+				seconds, _ := strconv.Atoi(args[i+1])
+				expiry = time.Duration(seconds) * time.Second
+				i++
+			case "px":
+				milliseconds, _ := strconv.Atoi(args[i+1])
+				expiry = time.Duration(milliseconds) * time.Millisecond
+				i++
+			case "nx":
+				nx = true
+			case "xx":
+				xx = true
+			}
+		}
+
+		_, exists := s.data[args[0]]
+		if (nx && exists) || (xx && !exists) {
+			return nil
+		}
+
+		s.data[args[0]] = args[1]
+		delete(s.expires, args[0])
+		if expiry > 0 {
+			s.expires[args[0]] = time.Now().Add(expiry)
+		}
+		s.touch(args[0])
+		return redisStatus("OK")
+	case "setnx":
+		if _, exists := s.data[args[0]]; exists {
+			return 0
+		}
+		s.data[args[0]] = args[1]
+		s.touch(args[0])
+		return 1
+	case "del":
+		count := 0
+		for _, key := range args {
+			if s.remove(key) {
+				count++
+			}
+		}
+		return count
+	case "exists":
+		count := 0
+		for _, key := range args {
+			if _, ok := s.data[key]; ok {
+				count++
+			}
+		}
+		return count
+	case "keys":
+		var keys []string
+		for key := range s.data {
+			if matched, _ := path.Match(args[0], key); matched {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		return keys
+	case "expire", "pexpire", "expireat", "pexpireat":
+		if _, ok := s.data[args[0]]; !ok {
+			return 0
+		}
+		value, _ := strconv.ParseInt(args[1], 10, 64)
+		switch name {
+		case "expire":
+			s.expires[args[0]] = time.Now().Add(time.Duration(value) * time.Second)
+		case "pexpire":
+			s.expires[args[0]] = time.Now().Add(time.Duration(value) * time.Millisecond)
+		case "expireat":
+			s.expires[args[0]] = time.Unix(value, 0)
+		case "pexpireat":
+			s.expires[args[0]] = time.Unix(0, value*int64(time.Millisecond))
+		}
+		s.expire(args[0])
+		return 1
+	case "ttl":
+		if _, ok := s.data[args[0]]; !ok {
+			return -2
+		}
+		at, ok := s.expires[args[0]]
+		if !ok {
+			return -1
+		}
+		return int(math.Ceil(time.Until(at).Seconds()))
+	case "incr", "incrby", "incrbyfloat":
+		current := float64(0)
+		if value, ok := s.data[args[0]]; ok {
+			current, _ = strconv.ParseFloat(value.(string), 64)
+		}
+		delta := float64(1)
+		if len(args) > 1 {
+			delta, _ = strconv.ParseFloat(args[1], 64)
+		}
+		current += delta
+		s.data[args[0]] = formatFloat(current)
+		s.touch(args[0])
+		if name == "incrbyfloat" {
+			return formatFloat(current)
+		}
+		return int(current)
+	case "hget":
+		hash, err := s.hash(args[0], false)
+		if err != nil {
+			return redisError(err.Error())
+		}
+		value, ok := hash[args[1]]
+		if !ok {
+			return nil
+		}
+		return value
+	case "hset", "hmset":
+		hash, err := s.hash(args[0], true)
+		if err != nil {
+			return redisError(err.Error())
+		}
+		added := 0
+		for i := 1; i+1 < len(args); i += 2 {
+			if _, ok := hash[args[i]]; !ok {
+				added++
+			}
+			hash[args[i]] = args[i+1]
+		}
+		s.touch(args[0])
+		if name == "hmset" {
+			return redisStatus("OK")
+		}
+		return added
+	case "hdel":
+		hash, err := s.hash(args[0], false)
+		if err != nil {
+			return redisError(err.Error())
+		}
+		count := 0
+		for _, field := range args[1:] {
+			if _, ok := hash[field]; ok {
+				delete(hash, field)
+				count++
+			}
+		}
+		if hash != nil && len(hash) < 1 {
+			s.remove(args[0])
+		} else if count > 0 {
+			s.touch(args[0])
+		}
+		return count
+	case "hexists":
+		hash, _ := s.hash(args[0], false)
+		if _, ok := hash[args[1]]; ok {
+			return 1
+		}
+		return 0
+	case "hlen":
+		hash, _ := s.hash(args[0], false)
+		return len(hash)
+	case "hkeys", "hgetall":
+		hash, err := s.hash(args[0], false)
+		if err != nil {
+			return redisError(err.Error())
+		}
+		var fields []string
+		for field := range hash {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		if name == "hkeys" {
+			return append([]string{}, fields...)
+		}
+		reply := []string{}
+		for _, field := range fields {
+			reply = append(reply, field, hash[field])
+		}
+		return reply
+	case "hincrby", "hincrbyfloat":
+		hash, err := s.hash(args[0], true)
+		if err != nil {
+			return redisError(err.Error())
+		}
+		current, _ := strconv.ParseFloat(hash[args[1]], 64)
+		delta, _ := strconv.ParseFloat(args[2], 64)
+		current += delta
+		hash[args[1]] = formatFloat(current)
+		s.touch(args[0])
+		if name == "hincrbyfloat" {
+			return formatFloat(current)
+		}
+		return int(current)
+	case "sadd":
+		set, err := s.set(args[0], true)
+		if err != nil {
+			return redisError(err.Error())
+		}
+		added := 0
+		for _, member := range args[1:] {
+			if !set[member] {
+				set[member] = true
+				added++
+			}
+		}
+		s.touch(args[0])
+		return added
+	case "srem":
+		set, err := s.set(args[0], false)
+		if err != nil {
+			return redisError(err.Error())
+		}
+		removed := 0
+		for _, member := range args[1:] {
+			if set[member] {
+				delete(set, member)
+				removed++
+			}
+		}
+		if set != nil && len(set) < 1 {
+			s.remove(args[0])
+		} else if removed > 0 {
+			s.touch(args[0])
+		}
+		return removed
+	case "smembers":
+		set, err := s.set(args[0], false)
+		if err != nil {
+			return redisError(err.Error())
+		}
+		members := []string{}
+		for member := range set {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		return members
+	case "sismember":
+		set, _ := s.set(args[0], false)
+		if set[args[1]] {
+			return 1
+		}
+		return 0
+	case "scard":
+		set, _ := s.set(args[0], false)
+		return len(set)
+	case "rpush", "lpush":
+		list, err := s.list(args[0])
+		if err != nil {
+			return redisError(err.Error())
+		}
+		for _, value := range args[1:] {
+			if name == "rpush" {
+				list = append(list, value)
+			} else {
+				list = append([]string{value}, list...)
+			}
+		}
+		s.storeList(args[0], list)
+		return len(list)
+	case "lrange":
+		list, err := s.list(args[0])
+		if err != nil {
+			return redisError(err.Error())
+		}
+		start, stop := rangeIndexes(args[1], args[2], len(list))
+		return append([]string{}, list[start:stop]...)
+	case "ltrim":
+		list, err := s.list(args[0])
+		if err != nil {
+			return redisError(err.Error())
+		}
+		start, stop := rangeIndexes(args[1], args[2], len(list))
+		s.storeList(args[0], append([]string{}, list[start:stop]...))
+		return redisStatus("OK")
+	case "llen":
+		list, _ := s.list(args[0])
+		return len(list)
+	case "lindex":
+		list, _ := s.list(args[0])
+		index, _ := strconv.Atoi(args[1])
+		if index < 0 {
+			index += len(list)
+		}
+		if index < 0 || index >= len(list) {
+			return nil
+		}
+		return list[index]
+	case "lrem":
+		list, err := s.list(args[0])
+		if err != nil {
+			return redisError(err.Error())
+		}
+		count, _ := strconv.Atoi(args[1])
+		limit := count
+		if limit < 0 {
+			limit = -limit
+		}
+		removed := 0
+		kept := []string{}
+		if count >= 0 {
+			for _, value := range list {
+				if value == args[2] && (limit == 0 || removed < limit) {
+					removed++
+					continue
+				}
+				kept = append(kept, value)
+			}
+		} else {
+			for i := len(list) - 1; i >= 0; i-- {
+				if list[i] == args[2] && removed < limit {
+					removed++
+					continue
+				}
+				kept = append([]string{list[i]}, kept...)
+			}
+		}
+		s.storeList(args[0], kept)
+		return removed
+	case "zadd":
+		zset, err := s.zset(args[0])
+		if err != nil {
+			return redisError(err.Error())
+		}
+		added := 0
+		for i := 1; i+1 < len(args); i += 2 {
+			score, _ := strconv.ParseFloat(args[i], 64)
+			found := false
+			for j := range zset {
+				if zset[j].member == args[i+1] {
+					zset[j].score = score
+					found = true
+				}
+			}
+			if !found {
+				zset = append(zset, zmember{member: args[i+1], score: score})
+				added++
+			}
+		}
+		s.storeZSet(args[0], zset)
+		return added
+	case "zrem":
+		zset, err := s.zset(args[0])
+		if err != nil {
+			return redisError(err.Error())
+		}
+		removed := 0
+		var kept []zmember
+		for _, item := range zset {
+			found := false
+			for _, member := range args[1:] {
+				if item.member == member {
+					found = true
+				}
+			}
+			if found {
+				removed++
+				continue
+			}
+			kept = append(kept, item)
+		}
+		s.storeZSet(args[0], kept)
+		return removed
+	case "zcard":
+		zset, _ := s.zset(args[0])
+		return len(zset)
+	case "zscore":
+		zset, _ := s.zset(args[0])
+		for _, item := range zset {
+			if item.member == args[1] {
+				return formatFloat(item.score)
+			}
+		}
+		return nil
+	case "zrange", "zrevrange":
+		zset, err := s.zset(args[0])
+		if err != nil {
+			return redisError(err.Error())
+		}
+		ordered := append([]zmember{}, zset...)
+		if name == "zrevrange" {
+			for i, j := 0, len(ordered)-1; i < j; i, j = i+1, j-1 {
+				ordered[i], ordered[j] = ordered[j], ordered[i]
+			}
+		}
+		start, stop := rangeIndexes(args[1], args[2], len(ordered))
+		withScores := len(args) > 3 && strings.ToLower(args[3]) == "withscores"
+		reply := []string{}
+		for _, item := range ordered[start:stop] {
+			reply = append(reply, item.member)
+			if withScores {
+				reply = append(reply, formatFloat(item.score))
+			}
+		}
+		return reply
+	case "zrangebyscore", "zremrangebyscore":
+		zset, err := s.zset(args[0])
+		if err != nil {
+			return redisError(err.Error())
+		}
+		min, minExclusive := parseScoreBound(args[1])
+		max, maxExclusive := parseScoreBound(args[2])
+		inRange := func(score float64) bool {
+			if score < min || (minExclusive && score == min) {
+				return false
+			}
+			return score < max || (!maxExclusive && score == max)
+		}
+
+		if name == "zremrangebyscore" {
+			removed := 0
+			var kept []zmember
+			for _, item := range zset {
+				if inRange(item.score) {
+					removed++
+					continue
+				}
+				kept = append(kept, item)
+			}
+			s.storeZSet(args[0], kept)
+			return removed
+		}
+
+		withScores := false
+		offset, count := 0, -1
+		for i := 3; i < len(args); i++ {
+			switch strings.ToLower(args[i]) {
+			case "withscores":
+				withScores = true
+			case "limit":
+				offset, _ = strconv.Atoi(args[i+1])
+				count, _ = strconv.Atoi(args[i+2])
+				i += 2
+			}
+		}
+
+		var items []zmember
+		for _, item := range zset {
+			if inRange(item.score) {
+				items = append(items, item)
+			}
+		}
+		if offset > len(items) {
+			offset = len(items)
+		}
+		items = items[offset:]
+		if count >= 0 && count < len(items) {
+			items = items[:count]
+		}
+
+		reply := []string{}
+		for _, item := range items {
+			reply = append(reply, item.member)
+			if withScores {
+				reply = append(reply, formatFloat(item.score))
+			}
+		}
+		return reply
+	case "zremrangebyrank":
+		zset, err := s.zset(args[0])
+		if err != nil {
+			return redisError(err.Error())
+		}
+		start, stop := rangeIndexes(args[1], args[2], len(zset))
+		kept := append(append([]zmember{}, zset[:start]...), zset[stop:]...)
+		s.storeZSet(args[0], kept)
+		return stop - start
+	}
+
+	return redisError("ERR unknown command '" + name + "'")
+}
+
+// commandKeys returns the keys a command reads so they can be expired first
+func commandKeys(name string, args []string) []string {
+	switch name {
+	case "ping", "flushdb", "flushall", "keys":
+		return nil
+	case "del", "exists":
+		return args
+	}
+
+	if len(args) > 0 {
+		return args[:1]
+	}
+	return nil
+}
+
+// testPublisher records every event it is asked to publish, failing while err is set
+type testPublisher struct {
+	mutex  sync.Mutex
+	events []PublishEvent
+	err    error
+}
+
+func (p *testPublisher) Publish(channel, event string, data interface{}) error {
+	return p.PublishBatch([]PublishEvent{{Channel: channel, Event: event, Data: data}})
+}
+
+func (p *testPublisher) PublishBatch(events []PublishEvent) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+
+	p.events = append(p.events, events...)
+	return nil
+}
+
+func (p *testPublisher) SetError(err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.err = err
+}
+
+func (p *testPublisher) Events() []PublishEvent {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return append([]PublishEvent{}, p.events...)
+}
+
+func TestTestRedis(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+	client := svc.RedisClient
+
+	err := client.Set("key", "value", 0).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := client.Get("key").Result()
+	if err != nil || value != "value" {
+		t.Fatalf("get = %q, %v", value, err)
+	}
+
+	_, err = client.Get("missing").Result()
+	if err != redis.Nil {
+		t.Fatalf("missing key error = %v", err)
+	}
+
+	pipe := client.TxPipeline()
+	pipe.HIncrByFloat("hash", "field", 1.5)
+	pipe.RPush("list", "a", "b", "c")
+	pipe.LTrim("list", -2, -1)
+	_, err = pipe.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, _ := client.LRange("list", 0, -1).Result()
+	if strings.Join(list, ",") != "b,c" {
+		t.Errorf("list = %v", list)
+	}
+
+	total, _ := client.HGet("hash", "field").Float64()
+	if total != 1.5 {
+		t.Errorf("hash field = %f", total)
+	}
+
+	server.Do("set", "expired", "value", "px", "1")
+	time.Sleep(5 * time.Millisecond)
+	if exists, _ := client.Exists("expired").Result(); exists != 0 {
+		t.Error("expired key still exists")
+	}
+}
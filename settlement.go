@@ -0,0 +1,270 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/a-h/round"
+	"github.com/gorilla/mux"
+)
+
+const (
+	BetStatusWon  = "won"
+	BetStatusLost = "lost"
+	BetStatusVoid = "void"
+)
+
+// Winning outcome recorded against voided markets
+const VoidOutcome = -1
+
+type Settlement struct {
+	MatchID        string            `json:"match_id"`
+	WinningOutcome int               `json:"winning_outcome"`
+	Void           bool              `json:"void"`
+	Reason         string            `json:"reason,omitempty"`
+	SettledAt      int64             `json:"settled_at"`
+	Entries        []SettlementEntry `json:"entries"`
+}
+
+// SettlementEntry is the immutable grading of a single bet
+type SettlementEntry struct {
+	BetID     string  `json:"bet_id"`
+	AccountID string  `json:"account_id"`
+	Result    string  `json:"result"`
+	Released  float64 `json:"released"`
+	Payout    float64 `json:"payout"`
+	Profit    float64 `json:"profit"`
+}
+
+type SettleRequest struct {
+	WinningOutcome int    `json:"winning_outcome"`
+	Void           bool   `json:"void"`
+	Reason         string `json:"reason"`
+}
+
+func SettlementKey(matchID string) string {
+	return "settlement-" + matchID
+}
+
+// Set of bet IDs whose settlement entry has been paid out
+func SettlementAppliedKey(matchID string) string {
+	return "settlement-applied-" + matchID
+}
+
+// IsSettled reports whether a match has a settlement ledger
+func (svc *Service) IsSettled(matchID string) (bool, error) {
+	exists, err := svc.RedisClient.Exists(SettlementKey(matchID)).Result()
+	return exists > 0, err
+}
+
+// SettleMatch grades every bet on a match against its winning outcome and pays out the accounts
+func (svc *Service) SettleMatch(matchID string, winningOutcome int) (Settlement, error) {
+	match, err := svc.GetMatchRecord(matchID)
+	if err != nil {
+		return Settlement{}, err
+	}
+
+	if winningOutcome < 0 || winningOutcome >= match.Outcomes {
+		return Settlement{}, fmt.Errorf("Invalid winning outcome %d for match %s", winningOutcome, matchID)
+	}
+
+	return svc.settle(matchID, winningOutcome, false, "")
+}
+
+// VoidMatch refunds every bet on a match, e.g. when it is postponed or abandoned
+func (svc *Service) VoidMatch(matchID, reason string) (Settlement, error) {
+	_, err := svc.GetMatchRecord(matchID)
+	if err != nil {
+		return Settlement{}, err
+	}
+
+	return svc.settle(matchID, VoidOutcome, true, reason)
+}
+
+// settle writes the settlement ledger once and then applies any entries that haven't been paid out,
+// so a settlement can be safely re-run after a failure. Bets are placed under the same lock and refused once
+// the ledger exists, so every bet on the match is graded
+func (svc *Service) settle(matchID string, winningOutcome int, void bool, reason string) (Settlement, error) {
+	accountMutex.Lock()
+	defer accountMutex.Unlock()
+
+	bets, err := svc.GetBets(MatchBetsKey(matchID))
+	if err != nil {
+		return Settlement{}, err
+	}
+
+	// Graded bets can't take any more fills, a voided match that hasn't started still has a live book
+	for _, bet := range bets {
+		svc.OrderBooks.Cancel(matchID, bet.Outcome, bet.OrderID)
+	}
+
+	settlement := Settlement{
+		MatchID:        matchID,
+		WinningOutcome: winningOutcome,
+		Void:           void,
+		Reason:         reason,
+		SettledAt:      time.Now().Unix(),
+		Entries:        []SettlementEntry{},
+	}
+
+	for _, bet := range bets {
+		settlement.Entries = append(settlement.Entries, GradeBet(bet, winningOutcome, void))
+	}
+
+	settlementJSON, err := json.Marshal(settlement)
+	if err != nil {
+		return settlement, err
+	}
+
+	created, err := svc.RedisClient.SetNX(SettlementKey(matchID), settlementJSON, 0).Result()
+	if err != nil {
+		return settlement, err
+	}
+
+	if !created {
+		// The ledger is immutable, a re-run must agree with the original result
+		var existing Settlement
+		err = svc.GetRedis(SettlementKey(matchID), &existing)
+		if err != nil {
+			return existing, err
+		}
+
+		if existing.WinningOutcome != winningOutcome || existing.Void != void {
+			return existing, fmt.Errorf("Match %s has already been settled", matchID)
+		}
+
+		settlement = existing
+	}
+
+	for _, entry := range settlement.Entries {
+		err = svc.applySettlementEntry(matchID, entry)
+		if err != nil {
+			return settlement, err
+		}
+	}
+
+	return settlement, nil
+}
+
+// GradeBet works out the result of a bet, its unmatched stake is always refunded
+func GradeBet(bet Bet, winningOutcome int, void bool) SettlementEntry {
+	entry := SettlementEntry{
+		BetID:     bet.ID,
+		AccountID: bet.AccountID,
+		Released:  bet.Liability,
+	}
+
+	matched := bet.Matched
+	won := bet.Outcome == winningOutcome
+
+	// Matched stake is graded at the price it was filled at, the reserve covers the limit price
+	// so anything it holds beyond the loss is returned
+	odds := bet.FillOdds()
+
+	switch {
+	case void:
+		entry.Result = BetStatusVoid
+	case bet.Side == SideBack && won:
+		entry.Result = BetStatusWon
+		entry.Profit = matched * (odds - 1)
+	case bet.Side == SideBack:
+		entry.Result = BetStatusLost
+		entry.Profit = -matched
+	case won:
+		// A lay loses when its outcome wins
+		entry.Result = BetStatusLost
+		entry.Profit = -matched * (odds - 1)
+	default:
+		entry.Result = BetStatusWon
+		entry.Profit = matched
+	}
+
+	entry.Profit = round.AwayFromZero(entry.Profit, 8)
+	entry.Payout = round.AwayFromZero(bet.Liability+entry.Profit, 8)
+
+	return entry
+}
+
+// applySettlementEntry releases a bet's reserved liability and credits its payout, skipping bets already paid out.
+// The account, bet and applied marker are written in one transaction so a failure can't pay a bet twice,
+// callers hold accountMutex
+func (svc *Service) applySettlementEntry(matchID string, entry SettlementEntry) error {
+	applied, err := svc.RedisClient.SIsMember(SettlementAppliedKey(matchID), entry.BetID).Result()
+	if err != nil || applied {
+		return err
+	}
+
+	var bet Bet
+	err = svc.GetRedis(BetKey(entry.BetID), &bet)
+	if err != nil {
+		return err
+	}
+
+	if bet.Status != BetStatusOpen {
+		return nil
+	}
+
+	account, err := svc.GetAccount(entry.AccountID)
+	if err != nil {
+		return err
+	}
+
+	account.Reserved = round.AwayFromZero(account.Reserved-entry.Released, 8)
+	account.Balance = round.AwayFromZero(account.Balance+entry.Payout, 8)
+	bet.Status = entry.Result
+
+	accountJSON, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+
+	betJSON, err := json.Marshal(bet)
+	if err != nil {
+		return err
+	}
+
+	pipe := svc.RedisClient.TxPipeline()
+	pipe.Set(AccountKey(account.ID), accountJSON, 0)
+	pipe.Set(BetKey(bet.ID), betJSON, 0)
+	pipe.SAdd(SettlementAppliedKey(matchID), bet.ID)
+	_, err = pipe.Exec()
+
+	return err
+}
+
+func (svc *Service) SettleMatchHandler(w http.ResponseWriter, r *http.Request) {
+	matchID := mux.Vars(r)["id"]
+
+	var request SettleRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid settlement", http.StatusBadRequest)
+		return
+	}
+
+	var settlement Settlement
+	if request.Void {
+		settlement, err = svc.VoidMatch(matchID, request.Reason)
+	} else {
+		settlement, err = svc.SettleMatch(matchID, request.WinningOutcome)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(settlement)
+}
+
+func (svc *Service) SettlementHandler(w http.ResponseWriter, r *http.Request) {
+	var settlement Settlement
+	err := svc.GetRedis(SettlementKey(mux.Vars(r)["id"]), &settlement)
+	if err != nil {
+		http.Error(w, "Settlement not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(settlement)
+}
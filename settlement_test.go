@@ -0,0 +1,252 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGradeBet(t *testing.T) {
+	tests := []struct {
+		name           string
+		bet            Bet
+		winningOutcome int
+		void           bool
+		result         string
+		payout         float64
+		profit         float64
+	}{
+		{
+			name:           "back wins",
+			bet:            Bet{Outcome: 0, Side: SideBack, Odds: 3, Stake: 10, Matched: 10, Liability: 10},
+			winningOutcome: 0,
+			result:         BetStatusWon,
+			payout:         30,
+			profit:         20,
+		},
+		{
+			name:           "back loses, unmatched stake refunded",
+			bet:            Bet{Outcome: 0, Side: SideBack, Odds: 3, Stake: 10, Matched: 4, Liability: 10},
+			winningOutcome: 1,
+			result:         BetStatusLost,
+			payout:         6,
+			profit:         -4,
+		},
+		{
+			name:           "partly matched back wins",
+			bet:            Bet{Outcome: 1, Side: SideBack, Odds: 2.5, Stake: 10, Matched: 6, Liability: 10},
+			winningOutcome: 1,
+			result:         BetStatusWon,
+			payout:         19,
+			profit:         9,
+		},
+		{
+			name:           "lay loses when its outcome wins",
+			bet:            Bet{Outcome: 0, Side: SideLay, Odds: 3, Stake: 10, Matched: 10, Liability: 20},
+			winningOutcome: 0,
+			result:         BetStatusLost,
+			payout:         0,
+			profit:         -20,
+		},
+		{
+			name:           "partly matched lay loses",
+			bet:            Bet{Outcome: 0, Side: SideLay, Odds: 3, Stake: 10, Matched: 5, Liability: 20},
+			winningOutcome: 0,
+			result:         BetStatusLost,
+			payout:         10,
+			profit:         -10,
+		},
+		{
+			name:           "back graded at its better fill price",
+			bet:            Bet{Outcome: 0, Side: SideBack, Odds: 2, MatchedOdds: 2.5, Stake: 10, Matched: 10, Liability: 10},
+			winningOutcome: 0,
+			result:         BetStatusWon,
+			payout:         25,
+			profit:         15,
+		},
+		{
+			name:           "lay filled below its limit keeps the rest of its reserve",
+			bet:            Bet{Outcome: 0, Side: SideLay, Odds: 3, MatchedOdds: 2.5, Stake: 10, Matched: 10, Liability: 20},
+			winningOutcome: 0,
+			result:         BetStatusLost,
+			payout:         5,
+			profit:         -15,
+		},
+		{
+			name:           "lay wins when another outcome wins",
+			bet:            Bet{Outcome: 0, Side: SideLay, Odds: 3, Stake: 10, Matched: 10, Liability: 20},
+			winningOutcome: 2,
+			result:         BetStatusWon,
+			payout:         30,
+			profit:         10,
+		},
+		{
+			name:           "void refunds the liability",
+			bet:            Bet{Outcome: 0, Side: SideLay, Odds: 3, Stake: 10, Matched: 10, Liability: 20},
+			winningOutcome: VoidOutcome,
+			void:           true,
+			result:         BetStatusVoid,
+			payout:         20,
+			profit:         0,
+		},
+	}
+
+	for _, test := range tests {
+		entry := GradeBet(test.bet, test.winningOutcome, test.void)
+
+		if entry.Result != test.result {
+			t.Errorf("%s: result = %s, expected %s", test.name, entry.Result, test.result)
+		}
+
+		if !approxEqual(entry.Payout, test.payout) || !approxEqual(entry.Profit, test.profit) {
+			t.Errorf("%s: payout = %f profit = %f, expected %f and %f", test.name, entry.Payout, entry.Profit, test.payout, test.profit)
+		}
+
+		if entry.Released != test.bet.Liability {
+			t.Errorf("%s: released = %f, expected %f", test.name, entry.Released, test.bet.Liability)
+		}
+	}
+}
+
+func TestSettleMatchRequiresMatch(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	_, err := svc.SettleMatch("missing", 0)
+	if err == nil {
+		t.Error("settled a match that doesn't exist")
+	}
+
+	_, err = svc.VoidMatch("missing", "abandoned")
+	if err == nil {
+		t.Error("voided a match that doesn't exist")
+	}
+}
+
+func TestSettleMatch(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	match := storeTestMatch(t, svc)
+	err := svc.StoreMatchRecords(map[string]Match{match.ID(): match})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account := createTestAccount(t, svc, 100)
+	bet, err := svc.PlaceBet(account.ID, BetRequest{MatchID: match.ID(), Outcome: 0, Side: SideBack, Stake: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = svc.SettleMatch(match.ID(), match.Outcomes)
+	if err == nil {
+		t.Fatal("settled with an outcome the match doesn't have")
+	}
+
+	// Running it twice must only pay out once
+	for i := 0; i < 2; i++ {
+		_, err = svc.SettleMatch(match.ID(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	updated, err := svc.GetAccount(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := 100 - bet.Liability + bet.Matched*bet.Odds + (bet.Stake - bet.Matched)
+	if !approxEqual(updated.Balance, expected) || updated.Reserved != 0 {
+		t.Errorf("balance = %f reserved = %f, expected %f and 0", updated.Balance, updated.Reserved, expected)
+	}
+
+	_, err = svc.SettleMatch(match.ID(), 1)
+	if err == nil {
+		t.Error("settled again with a different result")
+	}
+
+	_, err = svc.PlaceBet(account.ID, BetRequest{MatchID: match.ID(), Outcome: 0, Side: SideBack, Stake: 10})
+	if err == nil {
+		t.Error("bet placed on a settled match")
+	}
+
+	_, err = svc.PlaceOrder(match.ID(), OrderRequest{AccountID: account.ID, Outcome: 0, Side: SideLay, Odds: 1.5, Stake: 10})
+	if err == nil {
+		t.Error("order placed on a settled match")
+	}
+}
+
+func TestVoidCancelsRestingOrders(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	match := storeTestMatch(t, svc)
+	err := svc.StoreMatchRecords(map[string]Match{match.ID(): match})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	maker := createTestAccount(t, svc, 100)
+	resting, err := svc.PlaceOrder(match.ID(), OrderRequest{AccountID: maker.ID, Outcome: 0, Side: SideLay, Odds: 2.05, Stake: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = svc.VoidMatch(match.ID(), "postponed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if svc.OrderBooks.Cancel(match.ID(), 0, resting.Order.ID) {
+		t.Error("voided bet's order was still resting")
+	}
+
+	// Fills against the book now only take the seeded liquidity
+	order, fills, err := svc.OrderBooks.Place(Order{MatchID: match.ID(), Outcome: 0, Side: SideBack, Odds: 2.05, Stake: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fill := range fills {
+		if fill.LayBetID == resting.Bet.ID {
+			t.Errorf("order %s filled against the voided bet", order.ID)
+		}
+	}
+}
+
+func TestAdminOnly(t *testing.T) {
+	defer func(token string) { AdminToken = token }(AdminToken)
+
+	handler := AdminOnly(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		token         string
+		authorization string
+		status        int
+	}{
+		{"", "", http.StatusForbidden},
+		{"", "Bearer ", http.StatusForbidden},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "Bearer secret", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		AdminToken = test.token
+
+		request := httptest.NewRequest("POST", "/admin", nil)
+		if test.authorization != "" {
+			request.Header.Set("Authorization", test.authorization)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+
+		if recorder.Code != test.status {
+			t.Errorf("token %q with %q: status = %d, expected %d", test.token, test.authorization, recorder.Code, test.status)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Reasons a market can be suspended
+const (
+	SuspendedKickoff   = "kickoff"
+	SuspendedPriceMove = "price-move"
+	SuspendedStaleFeed = "stale-feed"
+)
+
+// Suspension policy, times are in seconds and each can be overridden from the environment
+var (
+	// Suspend markets this long before kickoff
+	SuspendBeforeKickoff = envInt64("SUSPEND_BEFORE_KICKOFF", 60)
+	// Suspend when any outcome's consensus probability moves by more than this fraction in one fetch
+	ConsensusMoveLimit = envFloat("CONSENSUS_MOVE_LIMIT", 0.1)
+	// How long a price move suspension lasts
+	PriceMoveSuspension = envInt64("PRICE_MOVE_SUSPENSION", 300)
+	// Suspend a league's markets when its feed hasn't updated for this long
+	FeedStaleTime = envInt64("FEED_STALE_TIME", 45*60)
+)
+
+var leagueMutex = &sync.Mutex{}
+
+// MarkLeagueUpdated records a successful feed update for a league
+func (svc *Service) MarkLeagueUpdated(leagueID string) {
+	leagueMutex.Lock()
+	defer leagueMutex.Unlock()
+
+	svc.Internals.LeagueUpdatedAt[leagueID] = time.Now()
+}
+
+// IsLeagueStale reports whether a league's feed hasn't updated within FeedStaleTime
+func (svc *Service) IsLeagueStale(leagueID string) bool {
+	leagueMutex.Lock()
+	defer leagueMutex.Unlock()
+
+	updatedAt, ok := svc.Internals.LeagueUpdatedAt[leagueID]
+	if !ok {
+		return true
+	}
+
+	return time.Since(updatedAt).Seconds() > float64(FeedStaleTime)
+}
+
+// ApplySuspensionPolicy flags a match as suspended if it is close to kickoff, recently moved or its feed is stale
+func (svc *Service) ApplySuspensionPolicy(match *Match, timeTo int64) {
+	match.Suspended = true
+
+	switch {
+	case timeTo <= SuspendBeforeKickoff:
+		match.SuspendedReason = SuspendedKickoff
+	case time.Now().Unix() < match.SuspendedUntil:
+		match.SuspendedReason = SuspendedPriceMove
+	case svc.IsLeagueStale(match.CompetitionID):
+		match.SuspendedReason = SuspendedStaleFeed
+	default:
+		match.Suspended = false
+		match.SuspendedReason = ""
+	}
+}
+
+// CheckConsensusMove suspends a match if its provider consensus has moved too far since the previous fetch
+func CheckConsensusMove(previous Match, match *Match) bool {
+	match.SuspendedUntil = previous.SuspendedUntil
+
+	if previous.Consensus == nil || match.Consensus == nil {
+		return false
+	}
+
+	for outcome, probability := range match.Consensus.Probabilities {
+		if outcome >= len(previous.Consensus.Probabilities) {
+			break
+		}
+
+		previousProbability := previous.Consensus.Probabilities[outcome]
+		if previousProbability <= 0 {
+			continue
+		}
+
+		if math.Abs(probability-previousProbability)/previousProbability > ConsensusMoveLimit {
+			match.SuspendedUntil = time.Now().Unix() + PriceMoveSuspension
+			return true
+		}
+	}
+
+	return false
+}
@@ -4,7 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"golang.org/x/text/transform"
 	"golang.org/x/text/unicode/norm"
@@ -79,3 +83,46 @@ func WriteDataToJSONFile(filename string, data interface{}) (err error) {
 	err = ioutil.WriteFile(filename+".json", dataJSON, 0644)
 	return
 }
+
+// envInt64 reads an integer setting from the environment, keeping the default when it is unset or invalid
+func envInt64(name string, fallback int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+// envFloat reads a decimal setting from the environment, keeping the default when it is unset or invalid
+func envFloat(name string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+// envDuration reads a duration such as 90s or 5m from the environment, keeping the default when it is unset or invalid
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+// envList reads a comma separated setting from the environment, skipping empty entries
+func envList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
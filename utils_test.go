@@ -0,0 +1,68 @@
+package service
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEnvSettings(t *testing.T) {
+	defer os.Unsetenv("TEST_SETTING")
+
+	tests := []struct {
+		value    string
+		int64    int64
+		float    float64
+		duration time.Duration
+	}{
+		{"", 7, 0.5, time.Minute},
+		{"invalid", 7, 0.5, time.Minute},
+		{"12", 12, 12, time.Minute},
+		{"0.25", 7, 0.25, time.Minute},
+		{"90s", 7, 0.5, 90 * time.Second},
+	}
+
+	for _, test := range tests {
+		os.Setenv("TEST_SETTING", test.value)
+
+		if value := envInt64("TEST_SETTING", 7); value != test.int64 {
+			t.Errorf("envInt64(%q) = %d, expected %d", test.value, value, test.int64)
+		}
+
+		if value := envFloat("TEST_SETTING", 0.5); value != test.float {
+			t.Errorf("envFloat(%q) = %f, expected %f", test.value, value, test.float)
+		}
+
+		if value := envDuration("TEST_SETTING", time.Minute); value != test.duration {
+			t.Errorf("envDuration(%q) = %s, expected %s", test.value, value, test.duration)
+		}
+	}
+}
+
+func TestEnvList(t *testing.T) {
+	defer os.Unsetenv("TEST_LIST")
+
+	tests := []struct {
+		value    string
+		expected []string
+	}{
+		{"", nil},
+		{"a", []string{"a"}},
+		{" a, b ,,c ", []string{"a", "b", "c"}},
+	}
+
+	for _, test := range tests {
+		os.Setenv("TEST_LIST", test.value)
+
+		values := envList("TEST_LIST")
+		if len(values) != len(test.expected) {
+			t.Errorf("envList(%q) = %v, expected %v", test.value, values, test.expected)
+			continue
+		}
+		for i := range values {
+			if values[i] != test.expected[i] {
+				t.Errorf("envList(%q) = %v, expected %v", test.value, values, test.expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/a-h/round"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+)
+
+const (
+	BetStatusOpen = "open"
+)
+
+var accountMutex = &sync.Mutex{}
+
+var ErrAccountNotFound = errors.New("Account not found")
+
+type Account struct {
+	ID        string  `json:"id"`
+	Balance   float64 `json:"balance"`
+	Reserved  float64 `json:"reserved"`
+	CreatedAt int64   `json:"created_at"`
+}
+
+type Bet struct {
+	ID        string  `json:"id"`
+	AccountID string  `json:"account_id"`
+	MatchID   string  `json:"match_id"`
+	Outcome   int     `json:"outcome"`
+	Side      string  `json:"side"`
+	Odds      float64 `json:"odds"`
+	Stake     float64 `json:"stake"`
+	Matched   float64 `json:"matched"`
+	// Stake weighted price of the bet's fills, which execute at the resting order's price rather than Odds
+	MatchedOdds float64 `json:"matched_odds,omitempty"`
+	Liability   float64 `json:"liability"`
+	OrderID     string  `json:"order_id"`
+	Status      string  `json:"status"`
+	PlacedAt    int64   `json:"placed_at"`
+}
+
+type CreditRequest struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+type BetRequest struct {
+	MatchID string  `json:"match_id"`
+	Outcome int     `json:"outcome"`
+	Side    string  `json:"side"`
+	Stake   float64 `json:"stake"`
+}
+
+func AccountKey(accountID string) string {
+	return "account-" + accountID
+}
+
+func AccountBetsKey(accountID string) string {
+	return "account-bets-" + accountID
+}
+
+func MatchBetsKey(matchID string) string {
+	return "match-bets-" + matchID
+}
+
+func BetKey(betID string) string {
+	return "bet-" + betID
+}
+
+// CreateAccount creates an empty demo account
+func (svc *Service) CreateAccount() (Account, error) {
+	id, err := generateRandomID()
+	if err != nil {
+		return Account{}, err
+	}
+
+	account := Account{
+		ID:        id,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	return account, svc.SetRedis(AccountKey(id), &account)
+}
+
+func (svc *Service) GetAccount(accountID string) (account Account, err error) {
+	err = svc.GetRedis(AccountKey(accountID), &account)
+	if err == redis.Nil {
+		err = ErrAccountNotFound
+	}
+	return
+}
+
+// CreditAccount converts an amount into GAS using the latest price data and adds it to an account's balance
+func (svc *Service) CreditAccount(accountID string, request CreditRequest) (Account, error) {
+	if request.Amount <= 0 {
+		return Account{}, errors.New("Credit amount must be positive")
+	}
+
+	amount, err := svc.ConvertToGAS(request.Amount, request.Currency)
+	if err != nil {
+		return Account{}, err
+	}
+
+	accountMutex.Lock()
+	defer accountMutex.Unlock()
+
+	account, err := svc.GetAccount(accountID)
+	if err != nil {
+		return account, err
+	}
+
+	account.Balance = round.AwayFromZero(account.Balance+amount, 8)
+
+	return account, svc.SetRedis(AccountKey(accountID), &account)
+}
+
+// ConvertToGAS converts an amount of a currency into GAS, defaulting to USD
+func (svc *Service) ConvertToGAS(amount float64, currency string) (float64, error) {
+	priceDetails := svc.Internals.PriceDetails
+
+	switch currency {
+	case "GAS":
+		return amount, nil
+	case "", "USD":
+		if priceDetails.ExchangeRate <= 0 {
+			return 0, errors.New("No exchange rate available")
+		}
+		return amount * priceDetails.ExchangeRate, nil
+	default:
+		price := priceDetails.CurrencyData["GAS"][currency]
+		if price <= 0 {
+			return 0, fmt.Errorf("No exchange rate available for %s", currency)
+		}
+		return amount / price, nil
+	}
+}
+
+// PlaceBet places a back or lay bet at the current best ladder price, reserving its liability from the account
+func (svc *Service) PlaceBet(accountID string, request BetRequest) (Bet, error) {
+	match, err := svc.GetMatch(request.MatchID)
+	if err != nil {
+		return Bet{}, err
+	}
+
+	odds, err := GetLadderPrice(match, request.Outcome, request.Side)
+	if err != nil {
+		return Bet{}, err
+	}
+
+	response, err := svc.placeBet(accountID, match, request.Outcome, request.Side, odds, request.Stake)
+	return response.Bet, err
+}
+
+// placeBet reserves a bet's liability from the account and submits it to the book as a limit order at the given odds
+func (svc *Service) placeBet(accountID string, match Match, outcome int, side string, odds, stake float64) (OrderResponse, error) {
+	if stake <= 0 {
+		return OrderResponse{}, errors.New("Stake must be positive")
+	}
+
+	// The book trades in two decimal prices and stakes, reserve against the order as it will rest
+	odds = round.AwayFromZero(odds, 2)
+	stake = round.AwayFromZero(stake, 2)
+	liability := GetLiability(side, stake, odds)
+
+	accountMutex.Lock()
+	defer accountMutex.Unlock()
+
+	// Settlement grades the bets it finds under the same lock, any placed afterwards would never be graded
+	settled, err := svc.IsSettled(match.ID())
+	if err != nil {
+		return OrderResponse{}, err
+	}
+
+	if settled {
+		return OrderResponse{}, fmt.Errorf("Match %s has been settled", match.ID())
+	}
+
+	account, err := svc.GetAccount(accountID)
+	if err != nil {
+		return OrderResponse{}, err
+	}
+
+	if account.Balance < liability {
+		return OrderResponse{}, fmt.Errorf("Insufficient balance, %v GAS required", liability)
+	}
+
+	id, err := generateRandomID()
+	if err != nil {
+		return OrderResponse{}, err
+	}
+
+	response, err := svc.submitOrder(match, Order{
+		Outcome: outcome,
+		Side:    side,
+		Odds:    odds,
+		Stake:   stake,
+		BetID:   id,
+	})
+	if err != nil {
+		return OrderResponse{}, err
+	}
+
+	bet := Bet{
+		ID:        id,
+		AccountID: accountID,
+		MatchID:   match.ID(),
+		Outcome:   outcome,
+		Side:      side,
+		Odds:      odds,
+		Stake:     stake,
+		Liability: liability,
+		OrderID:   response.Order.ID,
+		Status:    BetStatusOpen,
+		PlacedAt:  time.Now().Unix(),
+	}
+	for _, fill := range response.Fills {
+		bet.addMatched(fill.Stake, fill.Odds)
+	}
+
+	account.Balance = round.AwayFromZero(account.Balance-liability, 8)
+	account.Reserved = round.AwayFromZero(account.Reserved+liability, 8)
+
+	err = svc.storeBet(account, bet)
+	if err != nil {
+		// Nothing was recorded, so the order can't be left resting to fill against other bets
+		svc.OrderBooks.Cancel(bet.MatchID, outcome, bet.OrderID)
+		return OrderResponse{}, err
+	}
+
+	response.Bet = bet
+
+	// Resting orders this one took are other bets being matched
+	svc.addBetFills(response.Fills, id)
+
+	return response, nil
+}
+
+// storeBet writes a new bet with its account debit and adds it to the account and match bet lists in one
+// transaction, a bet missing from the match list would never be graded and its reserve never released
+func (svc *Service) storeBet(account Account, bet Bet) error {
+	accountJSON, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+
+	betJSON, err := json.Marshal(bet)
+	if err != nil {
+		return err
+	}
+
+	pipe := svc.RedisClient.TxPipeline()
+	pipe.Set(AccountKey(account.ID), accountJSON, 0)
+	pipe.Set(BetKey(bet.ID), betJSON, 0)
+	pipe.RPush(AccountBetsKey(account.ID), bet.ID)
+	pipe.RPush(MatchBetsKey(bet.MatchID), bet.ID)
+	_, err = pipe.Exec()
+
+	return err
+}
+
+// addMatched adds a fill to the bet's matched stake, keeping MatchedOdds at the stake weighted price of its fills
+func (bet *Bet) addMatched(stake, odds float64) {
+	stake = math.Min(stake, bet.Stake-bet.Matched)
+	if stake <= 0 {
+		return
+	}
+
+	matched := bet.Matched + stake
+	bet.MatchedOdds = round.AwayFromZero((bet.FillOdds()*bet.Matched+odds*stake)/matched, 8)
+	bet.Matched = round.AwayFromZero(matched, 8)
+}
+
+// FillOdds returns the price the bet's matched stake is graded at, bets stored before fill prices were
+// recorded fall back to their limit price
+func (bet Bet) FillOdds() float64 {
+	if bet.MatchedOdds > 0 {
+		return bet.MatchedOdds
+	}
+
+	return bet.Odds
+}
+
+// Position is the bet's matched stake at its fill price, as valued for a cash out
+func (bet Bet) Position() Position {
+	return Position{
+		Outcome: bet.Outcome,
+		Side:    bet.Side,
+		Stake:   bet.Matched,
+		Odds:    bet.FillOdds(),
+	}
+}
+
+// RecordBetFills adds fills against resting orders to the matched stake of the bets they were placed for
+func (svc *Service) RecordBetFills(fills []Fill) {
+	accountMutex.Lock()
+	defer accountMutex.Unlock()
+
+	svc.addBetFills(fills, "")
+}
+
+// addBetFills adds each fill to its bets other than the taker's, which is stored with its fills already counted.
+// A bet that can't be loaded or saved is logged and skipped so the others still get their fills,
+// callers hold accountMutex
+func (svc *Service) addBetFills(fills []Fill, takerBetID string) {
+	betFills := make(map[string][]Fill)
+	for _, fill := range fills {
+		for _, betID := range []string{fill.BackBetID, fill.LayBetID} {
+			if betID != "" && betID != takerBetID {
+				betFills[betID] = append(betFills[betID], fill)
+			}
+		}
+	}
+
+	for betID, fills := range betFills {
+		var bet Bet
+		err := svc.GetRedis(BetKey(betID), &bet)
+		if err != nil {
+			svc.Logger.Log("error", fmt.Sprintf("Unable to load bet %s for fills: %s", betID, err.Error()))
+			continue
+		}
+
+		if bet.Status != BetStatusOpen {
+			continue
+		}
+
+		for _, fill := range fills {
+			bet.addMatched(fill.Stake, fill.Odds)
+		}
+
+		err = svc.SetRedis(BetKey(betID), &bet)
+		if err != nil {
+			svc.Logger.Log("error", fmt.Sprintf("Unable to record fills on bet %s: %s", betID, err.Error()))
+		}
+	}
+}
+
+// GetBets loads every bet stored under a list of bet IDs
+func (svc *Service) GetBets(listKey string) ([]Bet, error) {
+	betIDs, err := svc.RedisClient.LRange(listKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	bets := make([]Bet, 0)
+	for _, betID := range betIDs {
+		var bet Bet
+		err = svc.GetRedis(BetKey(betID), &bet)
+		if err != nil {
+			return nil, err
+		}
+
+		bets = append(bets, bet)
+	}
+
+	return bets, nil
+}
+
+// GetLadderPrice returns the best price currently available to back or lay an outcome
+func GetLadderPrice(match Match, outcome int, side string) (float64, error) {
+	if match.MatchOdds == nil || outcome < 0 || outcome >= match.Outcomes {
+		return 0, fmt.Errorf("Invalid outcome %d for match %s", outcome, match.ID())
+	}
+
+	var ladder [][]Odds
+	switch side {
+	case SideBack:
+		ladder = match.MatchOdds.Back
+	case SideLay:
+		ladder = match.MatchOdds.Lay
+	default:
+		return 0, fmt.Errorf("Unknown bet side: %s", side)
+	}
+
+	if outcome >= len(ladder) || len(ladder[outcome]) < 1 {
+		return 0, errors.New("No price available")
+	}
+
+	return ladder[outcome][0].Odds, nil
+}
+
+// GetLiability returns the amount at risk for a bet, the stake when backing or the payout when laying
+func GetLiability(side string, stake, odds float64) float64 {
+	if side == SideLay {
+		return round.AwayFromZero(stake*(odds-1), 8)
+	}
+
+	return stake
+}
+
+func generateRandomID() (string, error) {
+	bytes := make([]byte, 8)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
+
+func (svc *Service) CreateAccountHandler(w http.ResponseWriter, r *http.Request) {
+	account, err := svc.CreateAccount()
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		http.Error(w, "Unable to create account", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(account)
+}
+
+func (svc *Service) AccountHandler(w http.ResponseWriter, r *http.Request) {
+	account, err := svc.GetAccount(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Account not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(account)
+}
+
+func (svc *Service) CreditAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var request CreditRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid credit request", http.StatusBadRequest)
+		return
+	}
+
+	account, err := svc.CreditAccount(mux.Vars(r)["id"], request)
+	if err == ErrAccountNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(account)
+}
+
+func (svc *Service) PlaceBetHandler(w http.ResponseWriter, r *http.Request) {
+	var request BetRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid bet", http.StatusBadRequest)
+		return
+	}
+
+	bet, err := svc.PlaceBet(mux.Vars(r)["id"], request)
+	if err == ErrAccountNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(bet)
+}
+
+func (svc *Service) AccountBetsHandler(w http.ResponseWriter, r *http.Request) {
+	bets, err := svc.GetBets(AccountBetsKey(mux.Vars(r)["id"]))
+	if err != nil {
+		svc.Logger.Log("error", err.Error())
+		http.Error(w, "Unable to fetch bets", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(bets)
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRestingOrderFillsUpdateBet(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	match := storeTestMatch(t, svc)
+	maker := createTestAccount(t, svc, 100)
+	taker := createTestAccount(t, svc, 100)
+
+	// Rests inside the spread until another account backs at its price
+	resting, err := svc.PlaceOrder(match.ID(), OrderRequest{AccountID: maker.ID, Outcome: 0, Side: SideLay, Odds: 2.05, Stake: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resting.Order.BetID != resting.Bet.ID {
+		t.Fatalf("resting order is for bet %s, expected %s", resting.Order.BetID, resting.Bet.ID)
+	}
+
+	tests := []struct {
+		stake   float64
+		matched float64
+	}{
+		{10, 10},
+		{15, 25},
+		// Only 5 is left resting, the rest of the back goes on to the seeded book
+		{20, 30},
+	}
+
+	for _, test := range tests {
+		_, err = svc.PlaceOrder(match.ID(), OrderRequest{AccountID: taker.ID, Outcome: 0, Side: SideBack, Odds: 2.05, Stake: test.stake})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var bet Bet
+		err = svc.GetRedis(BetKey(resting.Bet.ID), &bet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bet.Matched != test.matched || bet.MatchedOdds != 2.05 {
+			t.Errorf("resting bet matched = %f at %f, expected %f at 2.05", bet.Matched, bet.MatchedOdds, test.matched)
+		}
+	}
+}
+
+func TestTakerBetRecordsFillPrice(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	match := storeTestMatch(t, svc)
+	maker := createTestAccount(t, svc, 100)
+	taker := createTestAccount(t, svc, 100)
+
+	_, err := svc.PlaceOrder(match.ID(), OrderRequest{AccountID: maker.ID, Outcome: 0, Side: SideLay, Odds: 2.05, Stake: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Takes 10 resting inside the spread at 2.05 and 10 of the seeded lays at 2
+	response, err := svc.PlaceOrder(match.ID(), OrderRequest{AccountID: taker.ID, Outcome: 0, Side: SideBack, Odds: 2, Stake: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bet := response.Bet
+	if bet.Matched != 20 || !approxEqual(bet.MatchedOdds, 2.025) || bet.Odds != 2 {
+		t.Errorf("bet matched %f at %f with limit %f, expected 20 at 2.025 with limit 2", bet.Matched, bet.MatchedOdds, bet.Odds)
+	}
+
+	position := bet.Position()
+	if position.Stake != 20 || position.Odds != bet.MatchedOdds {
+		t.Errorf("position %+v, expected the matched stake at its fill price", position)
+	}
+
+	var stored Bet
+	err = svc.GetRedis(BetKey(bet.ID), &stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != bet {
+		t.Errorf("stored bet %+v, expected %+v", stored, bet)
+	}
+
+	for _, key := range []string{AccountBetsKey(taker.ID), MatchBetsKey(match.ID())} {
+		ids, err := svc.RedisClient.LRange(key, 0, -1).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) < 1 || ids[len(ids)-1] != bet.ID {
+			t.Errorf("%s holds %v, expected %s last", key, ids, bet.ID)
+		}
+	}
+}
+
+func TestRecordBetFillsSkipsBrokenBets(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	err := svc.SetRedis(BetKey("good"), &Bet{ID: "good", Side: SideLay, Odds: 3, Stake: 10, Status: BetStatusOpen})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Do("set", BetKey("broken"), "not a bet")
+
+	svc.RecordBetFills([]Fill{
+		{BackBetID: "missing", LayBetID: "good", Odds: 3, Stake: 2},
+		{BackBetID: "broken", LayBetID: "good", Odds: 2.5, Stake: 2},
+	})
+
+	var bet Bet
+	err = svc.GetRedis(BetKey("good"), &bet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bet.Matched != 4 || !approxEqual(bet.MatchedOdds, 2.75) {
+		t.Errorf("good bet matched %f at %f, expected 4 at 2.75", bet.Matched, bet.MatchedOdds)
+	}
+}
+
+func TestMissingAccountNotFound(t *testing.T) {
+	svc, server := newTestService(t)
+	defer server.Close()
+
+	match := storeTestMatch(t, svc)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/accounts/{id}/bets", svc.PlaceBetHandler).Methods("POST")
+	router.HandleFunc("/accounts/{id}/credit", svc.CreditAccountHandler).Methods("POST")
+	router.HandleFunc("/matches/{id}/orders", svc.PlaceOrderHandler).Methods("POST")
+
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/accounts/missing/bets", `{"match_id":"` + match.ID() + `","outcome":0,"side":"back","stake":1}`},
+		{"/matches/" + match.ID() + "/orders", `{"account_id":"missing","outcome":0,"side":"back","odds":2,"stake":1}`},
+		{"/accounts/missing/credit", `{"amount":1,"currency":"GAS"}`},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("POST", test.path, bytes.NewBufferString(test.body)))
+
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("%s status = %d, expected %d", test.path, recorder.Code, http.StatusNotFound)
+		}
+	}
+}